@@ -0,0 +1,77 @@
+package plog
+
+import (
+	"container/ring"
+)
+
+// SetArchive keeps the size most recent entries evicted from the RingBuffer by
+// overflow in a separate in-memory ring, which can be read with Archived. Archiving
+// doesn't prevent the eviction, and it works alongside the spill writer
+// Resizing the archive keeps the most recent archived entries that fit. Passing a size
+// of 0 or less disables archiving and discards archived entries
+func (r *RingBuffer) SetArchive(size int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var kept []*entry
+	if r.archive != nil {
+		kept = r.archived()
+	}
+	if size <= 0 {
+		r.archive = nil
+		return
+	}
+
+	r.archive = &prioRing{r: ring.New(size)}
+	if len(kept) > size {
+		kept = kept[len(kept)-size:]
+	}
+	for _, e := range kept {
+		r.archiveEntry(e)
+	}
+}
+
+// Archived returns the messages of the archived entries, oldest first
+// It returns nil if archiving is disabled
+func (r *RingBuffer) Archived() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.archive == nil {
+		return nil
+	}
+
+	entries := r.archived()
+	msgs := make([]string, len(entries))
+	for i, e := range entries {
+		msgs[i] = string(e.data)
+	}
+	return msgs
+}
+
+// archived returns the archived entries, oldest first
+// The caller must hold r.lock and the archive must be enabled
+func (r *RingBuffer) archived() []*entry {
+	entries := make([]*entry, 0, r.archive.n)
+	r.archive.r.Do(func(v interface{}) {
+		if v != nil {
+			entries = append(entries, v.(*entry))
+		}
+	})
+	return entries
+}
+
+// archiveEntry stores e in the archive, overwriting the oldest archived entry if the
+// archive is full. It does nothing if archiving is disabled
+// The caller must hold r.lock
+func (r *RingBuffer) archiveEntry(e *entry) {
+	if r.archive == nil {
+		return
+	}
+
+	if r.archive.r.Value == nil {
+		r.archive.n++
+	}
+	r.archive.r.Value = e
+	r.archive.r = r.archive.r.Next()
+}
@@ -0,0 +1,66 @@
+package plog
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestArchive runs subtests covering the RingBuffer's overflow archive
+func TestArchive(t *testing.T) {
+	t.Run("overwrite", testArchiveOverwrite)
+	t.Run("max entries", testArchiveMaxEntries)
+	t.Run("resize", testArchiveResize)
+}
+
+func testArchiveOverwrite(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	if rb.Archived() != nil {
+		t.Logf("expected nil archive before SetArchive\n")
+		t.Fail()
+	}
+
+	rb.SetArchive(2)
+	for i := 0; i < 5; i++ {
+		rb.Write([]byte(fmt.Sprint("minor", i)))
+	}
+	archivedWithExpected([]string{"minor1", "minor2"}, rb, t)
+	popWithExpected("minor4", rb, false, t)
+	popWithExpected("minor3", rb, false, t)
+}
+
+func testArchiveMaxEntries(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetMaxEntries(2)
+	rb.SetArchive(3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor1"))
+
+	archivedWithExpected([]string{"minor0"}, rb, t)
+}
+
+func testArchiveResize(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1)
+	rb.SetArchive(3)
+	for i := 0; i < 4; i++ {
+		rb.Write([]byte(fmt.Sprint("minor", i)))
+	}
+	archivedWithExpected([]string{"minor0", "minor1", "minor2"}, rb, t)
+
+	rb.SetArchive(2)
+	archivedWithExpected([]string{"minor1", "minor2"}, rb, t)
+
+	rb.SetArchive(0)
+	if rb.Archived() != nil {
+		t.Logf("expected nil archive after disabling\n")
+		t.Fail()
+	}
+}
+
+// archivedWithExpected asserts that the RingBuffer's archived messages match expected
+func archivedWithExpected(expected []string, rb *RingBuffer, t *testing.T) {
+	if archived := rb.Archived(); fmt.Sprint(archived) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", archived, expected)
+		t.Fail()
+	}
+}
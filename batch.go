@@ -0,0 +1,351 @@
+package plog
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchingBuffer wraps a Buffer and holds written entries back, storing them in the
+// wrapped Buffer in batches with PWriteBatch. A batch is stored once it reaches a
+// number of entries or bytes, or once its oldest entry has waited for an interval,
+// whichever comes first. This trades a short delay for fewer lock acquisitions and,
+// with Buffers such as FileBuffer, fewer system calls
+//
+// Held entries aren't visible through the wrapped Buffer, so Pop and PopEntry store
+// them before popping. Flush and Close store them on demand
+//
+// If the wrapped Buffer rejects a batch, the entries it didn't store stay held and
+// are retried with the next batch, so a failed batch isn't lost. Errors from batches
+// stored by writes or the timer are passed to the function set by OnError, while
+// Flush, Close, and Sync return them. Pop and PopEntry still pop from the wrapped
+// Buffer when storing the held entries fails, which makes room for them, and only
+// return the error if the wrapped Buffer is empty
+//
+// At most DefaultMaxHeld entries are held, or the limit set by SetMaxHeld. Once the
+// limit is exceeded, the oldest held entries are dropped and counted, and are reported
+// by TakeDropped along with the wrapped Buffer's drops
+type BatchingBuffer struct {
+	Buffer
+	count    int           // entries that trigger a batch, or 0
+	bytes    int           // bytes that trigger a batch, or 0
+	interval time.Duration // wait that triggers a batch, or 0
+
+	pending []batchRun // held entries, in write order
+	held    int        // number of held entries
+	size    int        // total length of held entries
+	timer   *time.Timer
+	timerID int                 // incremented for each timer, so a stale timer can tell
+	errFn   func(err error)     // receives errors from batches stored by writes or the timer
+	closed  bool                // whether writes bypass batching
+	maxHeld int                 // number of entries that can be held before dropping
+	dropped map[LogPriority]int // held entries dropped by the limit
+	kept    map[LogPriority]int // rejections the wrapped Buffer counted as drops
+	lock    *sync.Mutex
+}
+
+// DefaultMaxHeld is the number of entries a BatchingBuffer holds by default before it
+// drops the oldest
+const DefaultMaxHeld = 4096
+
+// batchRun is a sequence of held entries written consecutively with one priority,
+// which can be stored with a single call to PWriteBatch
+type batchRun struct {
+	p       LogPriority
+	entries [][]byte
+}
+
+// NewBatchingBuffer returns a BatchingBuffer that stores entries in b once count
+// entries or bytes bytes are held, or once interval has passed since the oldest held
+// entry was written. A trigger of 0 or less is disabled
+func NewBatchingBuffer(b Buffer, count, bytes int, interval time.Duration) *BatchingBuffer {
+	return &BatchingBuffer{
+		Buffer:   b,
+		count:    max(count, 0),
+		bytes:    max(bytes, 0),
+		interval: max(interval, 0),
+		maxHeld:  DefaultMaxHeld,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// SetMaxHeld limits the number of held entries to n, or to the count trigger if that's
+// larger. Once a write exceeds the limit, the oldest held entries are dropped. A limit
+// of 0 or less removes the limit. Lowering the limit does not drop held entries until
+// the next write
+func (bb *BatchingBuffer) SetMaxHeld(n int) {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	bb.maxHeld = max(n, 0)
+}
+
+// TakeDropped implements DropCounter, returning the number of entries of each priority
+// dropped because too many were held since the previous call, plus those reported by
+// the wrapped Buffer if it implements DropCounter, and resetting the counts. Entries
+// the wrapped Buffer rejected but that stay held aren't counted
+func (bb *BatchingBuffer) TakeDropped() map[LogPriority]int {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	dropped := bb.dropped
+	if dropped == nil {
+		dropped = make(map[LogPriority]int)
+	}
+	if dc, ok := bb.Buffer.(DropCounter); ok {
+		for p, n := range dc.TakeDropped() {
+			dropped[p] += n
+		}
+	}
+	for p, n := range bb.kept {
+		dropped[p] -= n
+		if dropped[p] <= 0 {
+			delete(dropped, p)
+		}
+	}
+	bb.dropped, bb.kept = nil, nil
+	return dropped
+}
+
+// trim drops the oldest held entries until no more than the limit set by SetMaxHeld
+// are held
+// The caller must hold bb.lock
+func (bb *BatchingBuffer) trim() {
+	if bb.maxHeld <= 0 {
+		return
+	}
+
+	for bb.held > max(bb.maxHeld, bb.count) {
+		run := &bb.pending[0]
+		bb.held--
+		bb.size -= len(run.entries[0])
+		run.entries = run.entries[1:]
+		if bb.dropped == nil {
+			bb.dropped = make(map[LogPriority]int)
+		}
+		bb.dropped[run.p]++
+		if len(run.entries) == 0 {
+			bb.pending = bb.pending[1:]
+		}
+	}
+}
+
+// cloneEmpty returns a BatchingBuffer with the same triggers and settings as bb,
+// wrapping an empty clone of bb's Buffer, or nil if that Buffer can't be cloned
+func (bb *BatchingBuffer) cloneEmpty() Buffer {
+	c, ok := bb.Buffer.(emptyCloner)
+	if !ok {
+		return nil
+	}
+	b := c.cloneEmpty()
+	if b == nil {
+		return nil
+	}
+
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	clone := NewBatchingBuffer(b, bb.count, bb.bytes, bb.interval)
+	clone.maxHeld = bb.maxHeld
+	clone.errFn = bb.errFn
+	return clone
+}
+
+// Write holds b to be stored at the wrapped Buffer's default priority
+func (bb *BatchingBuffer) Write(b []byte) (int, error) {
+	return bb.PWrite(bb.GetPriority(), b)
+}
+
+// OnError sets fn to receive the error from each batch that a write or the timer
+// fails to store. The entries that weren't stored stay held for the next batch. fn
+// is called without the BatchingBuffer locked; if it panics, the panic is recovered
+// Passing nil removes it
+func (bb *BatchingBuffer) OnError(fn func(err error)) {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	bb.errFn = fn
+}
+
+// report passes err to the function set by OnError, if err isn't nil and one is set
+func (bb *BatchingBuffer) report(fn func(error), err error) {
+	if fn == nil || err == nil {
+		return
+	}
+	safeCall(func() { fn(err) })
+}
+
+// PWrite holds a copy of b to be stored with priority p, storing the held entries if
+// that triggers a batch. Once b is held, PWrite succeeds; an error storing the batch
+// is passed to the function set by OnError instead
+func (bb *BatchingBuffer) PWrite(p LogPriority, b []byte) (int, error) {
+	if _, err := bb.PWriteBatch(p, [][]byte{b}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// PWriteBatch holds a copy of each of entries to be stored with priority p, like
+// PWrite, and returns len(entries). After Close, entries are written directly to the
+// wrapped Buffer, and its result is returned
+func (bb *BatchingBuffer) PWriteBatch(p LogPriority, entries [][]byte) (int, error) {
+	bb.lock.Lock()
+	if bb.closed {
+		defer bb.lock.Unlock()
+		return bb.Buffer.PWriteBatch(p, entries)
+	}
+
+	if n := len(bb.pending); n == 0 || bb.pending[n-1].p != p {
+		bb.pending = append(bb.pending, batchRun{p: p})
+	}
+	run := &bb.pending[len(bb.pending)-1]
+	for _, b := range entries {
+		run.entries = append(run.entries, append([]byte(nil), b...))
+		bb.held++
+		bb.size += len(b)
+	}
+
+	var err error
+	if (bb.count > 0 && bb.held >= bb.count) || (bb.bytes > 0 && bb.size >= bb.bytes) {
+		err = bb.flush()
+	}
+	bb.trim()
+	if bb.held > 0 && bb.interval > 0 && bb.timer == nil {
+		bb.timerID++
+		id := bb.timerID
+		bb.timer = time.AfterFunc(bb.interval, func() { bb.timedFlush(id) })
+	}
+	fn := bb.errFn
+	bb.lock.Unlock()
+
+	bb.report(fn, err)
+	return len(entries), nil
+}
+
+// timedFlush stores the held entries once the interval has passed for timer id
+// A timer that fired while its batch was being stored by other means does nothing
+func (bb *BatchingBuffer) timedFlush(id int) {
+	bb.lock.Lock()
+	if id != bb.timerID || bb.timer == nil {
+		bb.lock.Unlock()
+		return
+	}
+	bb.timer = nil
+	err := bb.flush()
+	fn := bb.errFn
+	bb.lock.Unlock()
+
+	bb.report(fn, err)
+}
+
+// Flush stores the held entries in the wrapped Buffer now
+// If the wrapped Buffer rejects an entry, the error is returned and the entries that
+// haven't been stored stay held
+func (bb *BatchingBuffer) Flush() error {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	return bb.flush()
+}
+
+// flush implements Flush
+// The caller must hold bb.lock
+func (bb *BatchingBuffer) flush() error {
+	if bb.timer != nil {
+		bb.timer.Stop()
+		bb.timer = nil
+	}
+
+	for len(bb.pending) > 0 {
+		run := &bb.pending[0]
+		n, err := bb.Buffer.PWriteBatch(run.p, run.entries)
+		for _, b := range run.entries[:n] {
+			bb.held--
+			bb.size -= len(b)
+		}
+		run.entries = run.entries[n:]
+		if err != nil {
+			if _, ok := bb.Buffer.(DropCounter); ok && err == ErrBufferFull {
+				// the wrapped Buffer counts the rejected entry as dropped, but it's
+				// still held
+				if bb.kept == nil {
+					bb.kept = make(map[LogPriority]int)
+				}
+				bb.kept[run.p]++
+			}
+			return err
+		}
+		bb.pending = bb.pending[1:]
+	}
+	bb.pending = nil
+	return nil
+}
+
+// Close stores the held entries and stops batching, so later writes go directly to
+// the wrapped Buffer. The wrapped Buffer isn't closed
+// If storing the held entries fails, Close returns the error and keeps batching
+func (bb *BatchingBuffer) Close() error {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	if err := bb.flush(); err != nil {
+		return err
+	}
+	bb.closed = true
+	return nil
+}
+
+// Sync stores the held entries and, if the wrapped Buffer implements Syncer, syncs it
+func (bb *BatchingBuffer) Sync() error {
+	if err := bb.Flush(); err != nil {
+		return err
+	}
+	if sy, ok := bb.Buffer.(Syncer); ok {
+		return sy.Sync()
+	}
+	return nil
+}
+
+// Available returns the number of entries the wrapped Buffer can accept, less the
+// held entries, or -1 if the wrapped Buffer is unbounded
+func (bb *BatchingBuffer) Available() int {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	n := bb.Buffer.Available()
+	if n < 0 {
+		return n
+	}
+	return max(n-bb.held, 0)
+}
+
+// Len returns the number of entries in the wrapped Buffer plus the held entries
+func (bb *BatchingBuffer) Len() int {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+
+	return bb.Buffer.Len() + bb.held
+}
+
+// Pop stores the held entries and pops from the wrapped Buffer. If storing the held
+// entries fails, Pop still pops from the wrapped Buffer, and returns the error only
+// if the wrapped Buffer is empty
+func (bb *BatchingBuffer) Pop(priPrefix bool) (string, error) {
+	flushErr := bb.Flush()
+	s, err := bb.Buffer.Pop(priPrefix)
+	if err == ErrBufferEmpty && flushErr != nil {
+		return "", flushErr
+	}
+	return s, err
+}
+
+// PopEntry stores the held entries and pops an entry from the wrapped Buffer, with
+// its priority and time if the wrapped Buffer implements EntryPopper. A failure to
+// store the held entries is handled as it is by Pop
+func (bb *BatchingBuffer) PopEntry() (Entry, error) {
+	flushErr := bb.Flush()
+	e, err := popEntry(bb.Buffer)
+	if err == ErrBufferEmpty && flushErr != nil {
+		return Entry{}, flushErr
+	}
+	return e, err
+}
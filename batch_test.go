@@ -0,0 +1,201 @@
+package plog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatchingBuffer runs subtests covering BatchingBuffer triggers
+func TestBatchingBuffer(t *testing.T) {
+	t.Run("count", testBatchingBufferCount)
+	t.Run("bytes", testBatchingBufferBytes)
+	t.Run("interval", testBatchingBufferInterval)
+	t.Run("close", testBatchingBufferClose)
+	t.Run("error", testBatchingBufferError)
+	t.Run("full", testBatchingBufferFull)
+}
+
+// storedWithExpected asserts that rb holds expected entries
+func storedWithExpected(expected int, rb *RingBuffer, t *testing.T) {
+	if entries, _, _ := rb.MemStats(); entries != expected {
+		t.Logf("expected %d stored entries, got %d\n", expected, entries)
+		t.Fail()
+	}
+}
+
+func testBatchingBufferCount(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	bb := NewBatchingBuffer(rb, 3, 0, 0)
+	bb.Write([]byte("minor0"))
+	bb.PWrite(Critical, []byte("critical0"))
+	storedWithExpected(0, rb, t)
+	if n := bb.Len(); n != 2 {
+		t.Logf("expected 2 held entries, got %d\n", n)
+		t.Fail()
+	}
+
+	bb.Write([]byte("minor1"))
+	storedWithExpected(3, rb, t)
+	popWithExpected("critical0", rb, false, t)
+	popWithExpected("minor1", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+}
+
+func testBatchingBufferBytes(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	bb := NewBatchingBuffer(rb, 0, 10, 0)
+	bb.Write([]byte("minor0"))
+	storedWithExpected(0, rb, t)
+
+	bb.Write([]byte("minor1"))
+	storedWithExpected(2, rb, t)
+}
+
+func testBatchingBufferInterval(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	bb := NewBatchingBuffer(rb, 0, 0, time.Millisecond)
+	bb.Write([]byte("minor0"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rb.Bytes() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	popWithExpected("minor0", rb, false, t)
+}
+
+// testBatchingBufferClose asserts that popping and closing store held entries, and
+// that writes after Close aren't held
+func testBatchingBufferClose(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	bb := NewBatchingBuffer(rb, 10, 0, 0)
+	bb.Write([]byte("minor0"))
+	bb.Write([]byte("minor1"))
+	if s, err := bb.Pop(false); err != nil || s != "minor1" {
+		t.Logf("err: %v || %s != %s\n", err, s, "minor1")
+		t.Fail()
+	}
+
+	bb.Write([]byte("minor2"))
+	if err := bb.Close(); err != nil {
+		t.Logf("unexpected close error: %v\n", err)
+		t.Fail()
+	}
+	storedWithExpected(2, rb, t)
+
+	bb.Write([]byte("minor3"))
+	storedWithExpected(3, rb, t)
+}
+
+// testBatchingBufferError asserts that entries the wrapped Buffer rejects stay held
+// for the next batch, and that batch errors go to the OnError function rather than
+// the writes that triggered them
+func testBatchingBufferError(t *testing.T) {
+	sb := NewSliceBuffer(Minor, 2)
+	bb := NewBatchingBuffer(sb, 3, 0, 0)
+	var errs []error
+	bb.OnError(func(err error) { errs = append(errs, err) })
+
+	for _, s := range []string{"minor0", "minor1", "minor2"} {
+		if n, err := bb.Write([]byte(s)); err != nil || n != len(s) {
+			t.Logf("expected %d, nil, got %d, %v\n", len(s), n, err)
+			t.Fail()
+		}
+	}
+	if len(errs) != 1 || errs[0] != ErrBufferFull {
+		t.Logf("expected [%v], got %v\n", ErrBufferFull, errs)
+		t.Fail()
+	}
+	if n := bb.Len(); n != 3 {
+		t.Logf("expected 3 entries, got %d\n", n)
+		t.Fail()
+	}
+	if err := bb.Close(); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+
+	var popped []string
+	popAll := func() {
+		for s, err := sb.Pop(false); err == nil; s, err = sb.Pop(false) {
+			popped = append(popped, s)
+		}
+	}
+	popAll()
+	if err := bb.Flush(); err != nil {
+		t.Logf("unexpected flush error: %v\n", err)
+		t.Fail()
+	}
+	popAll()
+	if len(popped) != 3 || popped[0] != "minor1" || popped[1] != "minor0" || popped[2] != "minor2" {
+		t.Logf("unexpected entries %q\n", popped)
+		t.Fail()
+	}
+
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetOverflowPolicy(RejectNewest)
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor1"))
+	bb = NewBatchingBuffer(rb, 0, 0, time.Millisecond)
+	timed := make(chan error, 1)
+	var once sync.Once
+	bb.OnError(func(err error) {
+		once.Do(func() { timed <- err })
+	})
+	bb.Write([]byte("minor2"))
+	select {
+	case err := <-timed:
+		if err != ErrBufferFull {
+			t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Log("timed out waiting for the timer's error")
+		t.Fail()
+	}
+	if n, err := bb.Write([]byte("minor3")); err != nil || n != len("minor3") {
+		t.Logf("expected %d, nil, got %d, %v\n", len("minor3"), n, err)
+		t.Fail()
+	}
+}
+
+// testBatchingBufferFull asserts that a Logger can drain a BatchingBuffer whose
+// wrapped RingBuffer rejects the held entries, and that the number of held entries is
+// bounded, with the drops counted
+func testBatchingBufferFull(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetOverflowPolicy(RejectNewest)
+	bb := NewBatchingBuffer(rb, 1, 0, 0)
+	bb.SetMaxHeld(2)
+	l := NewLogger(bb)
+	for i := 0; i < 6; i++ {
+		l.Print(Minor, fmt.Sprint("minor", i))
+	}
+	if n := bb.Len(); n != 4 {
+		t.Logf("expected 4 entries, got %d\n", n)
+		t.Fail()
+	}
+	if dropped := bb.TakeDropped(); dropped[Minor] != 2 {
+		t.Logf("expected 2 dropped entries, got %v\n", dropped)
+		t.Fail()
+	}
+
+	var out bytes.Buffer
+	if _, err := l.FlushTo(&out); err != nil {
+		t.Logf("unexpected flush error: %v\n", err)
+		t.Fail()
+	}
+	if n := bb.Len(); n != 0 || strings.Count(out.String(), "\n") != 4 {
+		t.Logf("expected 4 entries flushed, got %q with %d left\n", out.String(), n)
+		t.Fail()
+	}
+	for _, s := range []string{"minor0", "minor1", "minor4", "minor5"} {
+		if !strings.Contains(out.String(), s) {
+			t.Logf("expected %q in %q\n", s, out.String())
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package plog
+
+import (
+	"fmt"
+)
+
+// safeCall runs fn, which invokes a user-supplied callback, and returns any panic it
+// raises as an error rather than letting it unwind through the caller
+func safeCall(fn func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("recovered callback panic: %v", v)
+		}
+	}()
+
+	fn()
+	return nil
+}
+
+// safeFormat returns the result of format, which formats user-supplied operands, or a
+// placeholder if it panics. fmt already recovers panics from String and Error
+// methods, but it re-panics if formatting the recovered value panics as well, so the
+// placeholder names the panic value's type rather than formatting it again
+func safeFormat(format func() string) (s string) {
+	defer func() {
+		if v := recover(); v != nil {
+			s = fmt.Sprintf("[format panic: %T]", v)
+		}
+	}()
+
+	return format()
+}
+
+// safeLess wraps a user-supplied priority comparator so that a panic is recorded and
+// the default ordering is used for that comparison
+func (r *RingBuffer) safeLess(less func(a, b LogPriority) bool) func(a, b LogPriority) bool {
+	return func(a, b LogPriority) (ret bool) {
+		err := safeCall(func() {
+			ret = less(a, b)
+		})
+		if err != nil {
+			r.recovered(err)
+			ret = numericLess(a, b)
+		}
+		return ret
+	}
+}
+
+// SetPanicLogging sets whether panics recovered from the RingBuffer's callbacks, such
+// as the priority comparator and the spill writer, are stored as Critical entries
+// Recovered panics are stored by the next write to the RingBuffer. Disabling panic
+// logging discards recovered panics that haven't been stored yet
+func (r *RingBuffer) SetPanicLogging(enabled bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.logPanics = enabled
+	if !enabled {
+		r.panics = nil
+	}
+}
+
+// OnPriorityFull sets fn to be called each time the ring for priority p reaches
+// capacity, after which further writes with priority p overwrite its oldest entries
+// or, with the RejectNewest policy, are rejected. This reports pressure on a single
+// priority, such as Critical, apart from the RingBuffer as a whole
+// fn is called once the write that filled the ring has released the RingBuffer's
+// lock, so it may use the RingBuffer. A nil fn removes the callback for p
+func (r *RingBuffer) OnPriorityFull(p LogPriority, fn func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if fn == nil {
+		delete(r.fullFns, p)
+		return
+	}
+	if r.fullFns == nil {
+		r.fullFns = make(map[LogPriority]func())
+	}
+	r.fullFns[p] = fn
+}
+
+// unlock releases r.lock and then runs the OnPriorityFull callbacks queued while it
+// was held. Methods that store entries release the lock with unlock
+func (r *RingBuffer) unlock() {
+	queue := r.fullQueue
+	r.fullQueue = nil
+	r.lock.Unlock()
+
+	for _, fn := range queue {
+		if err := safeCall(fn); err != nil {
+			r.lock.Lock()
+			r.recovered(err)
+			r.lock.Unlock()
+		}
+	}
+}
+
+// recovered records a panic recovered from a callback
+// The caller must hold r.lock
+func (r *RingBuffer) recovered(err error) {
+	if r.logPanics && !r.loggingPanics {
+		r.panics = append(r.panics, err.Error())
+	}
+}
+
+// writePanics stores the recorded panics as Critical entries. Panics recovered while
+// doing so are not recorded, so a callback that always panics can't recurse
+// The caller must hold r.lock
+func (r *RingBuffer) writePanics() {
+	if len(r.panics) == 0 {
+		return
+	}
+
+	r.loggingPanics = true
+	for _, msg := range r.panics {
+		r.pwrite(Critical, []byte(msg))
+	}
+	r.panics = nil
+	r.loggingPanics = false
+}
+
+// SetPanicLogging sets whether panics recovered from the Logger's output writer are
+// printed to the Buffer as Critical entries
+func (l *Logger) SetPanicLogging(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.logPanics = enabled
+}
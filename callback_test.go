@@ -0,0 +1,123 @@
+package plog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCallbackPanic runs subtests that register panicking callbacks and assert that
+// the buffer stays usable
+func TestCallbackPanic(t *testing.T) {
+	t.Run("comparator", testCallbackPanicComparator)
+	t.Run("spill writer", testCallbackPanicSpill)
+	t.Run("output", testCallbackPanicOutput)
+	t.Run("printf", testCallbackPanicPrintf)
+	t.Run("priority full", testCallbackPanicPriorityFull)
+	t.Run("eviction score", testCallbackPanicEvictionScore)
+}
+
+// panicStringer is a fmt.Stringer that panics with itself, so fmt's own recovery
+// panics again while formatting the panic value
+type panicStringer struct{}
+
+func (panicStringer) String() string {
+	panic(panicStringer{})
+}
+
+func testCallbackPanicPrintf(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Printf(Major, "%v", panicStringer{})
+	popWithExpected("[format panic: plog.panicStringer]", rb, false, t)
+}
+
+// panicWriter is an io.Writer that always panics
+type panicWriter struct{}
+
+func (panicWriter) Write([]byte) (int, error) {
+	panic("nemo")
+}
+
+func testCallbackPanicComparator(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetPanicLogging(true)
+	rb.SetPriorityComparator(func(a, b LogPriority) bool { panic("nemo") })
+
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Major, []byte("major0"))
+
+	s, err := rb.Pop(false)
+	if err != nil || !strings.Contains(s, "recovered callback panic: nemo") {
+		t.Logf("expected recovered panic entry, got %q, %v\n", s, err)
+		t.Fail()
+	}
+	rb.SetPanicLogging(false)
+	rb.SetPriorityComparator(nil)
+	rb.Reset()
+	rb.Write([]byte("minor1"))
+	popWithExpected("minor1", rb, false, t)
+}
+
+func testCallbackPanicSpill(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1)
+	rb.SetSpillWriter(panicWriter{})
+
+	rb.Write([]byte("0"))
+	if _, err := rb.Write([]byte("1")); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+	popWithExpected("1", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testCallbackPanicPriorityFull(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1)
+	rb.SetPanicLogging(true)
+	rb.OnPriorityFull(Minor, func() { panic("nemo") })
+
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Major, []byte("major0"))
+	s, err := rb.Pop(true)
+	if err != nil || !strings.HasPrefix(s, "Critical recovered callback panic") {
+		t.Logf("err: %v || unexpected entry %q\n", err, s)
+		t.Fail()
+	}
+}
+
+// testCallbackPanicEvictionScore asserts that a panicking eviction scorer falls back
+// to the default eviction
+func testCallbackPanicEvictionScore(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetMaxEntries(2)
+	rb.SetEvictionScore(func(Entry, time.Duration) float64 { panic("nemo") })
+
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor0"))
+	if _, err := rb.Write([]byte("minor1")); err != nil {
+		t.Logf("unexpected write error: %v\n", err)
+		t.Fail()
+	}
+	popWithExpected("critical0", rb, false, t)
+	popWithExpected("minor1", rb, false, t)
+}
+
+func testCallbackPanicOutput(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetOutput(panicWriter{})
+	l.SetPanicLogging(true)
+
+	l.Print(Minor, "minor0")
+
+	s, err := rb.Pop(true)
+	if err != nil || !strings.HasPrefix(s, "Critical recovered callback panic") {
+		t.Logf("expected recovered panic entry, got %q, %v\n", s, err)
+		t.Fail()
+	}
+	popWithExpected("minor0", rb, false, t)
+}
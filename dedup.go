@@ -0,0 +1,161 @@
+package plog
+
+import (
+	"sync"
+	"time"
+)
+
+// DedupBuffer wraps a Buffer and drops entries that repeat a message already written
+// within a time window
+//
+// By default, duplicates are detected per priority, so the same message written at
+// two priorities is stored twice. With promotion enabled, duplicates are detected
+// across priorities: a repeat at a higher priority replaces the buffered copy, and a
+// repeat at the same or a lower priority is dropped. Priorities are compared with the
+// wrapped Buffer's priority comparator, if it has one. Replacing the buffered copy
+// requires a Buffer that supports removal, such as RingBuffer; for other Buffers the
+// higher priority copy is written alongside the original. The buffered copy is only
+// removed once the higher priority copy is written, so a rejected write keeps it
+type DedupBuffer struct {
+	Buffer
+	window  time.Duration
+	promote bool
+	seen    map[dedupKey]dedupRecord
+	pruned  time.Time
+	lock    *sync.Mutex
+}
+
+// dedupKey identifies a message, and its priority when promotion is disabled
+type dedupKey struct {
+	p   LogPriority
+	msg string
+}
+
+// dedupRecord holds the priority and time a message was last stored at
+type dedupRecord struct {
+	p LogPriority
+	t time.Time
+}
+
+// NewDedupBuffer returns a DedupBuffer that writes to b and drops repeated messages
+// within window
+func NewDedupBuffer(b Buffer, window time.Duration) *DedupBuffer {
+	return &DedupBuffer{
+		Buffer: b,
+		window: window,
+		seen:   make(map[dedupKey]dedupRecord),
+		pruned: time.Now(),
+		lock:   &sync.Mutex{},
+	}
+}
+
+// SetPromotion enables or disables cross-priority deduplication
+// Messages seen before the setting changed are forgotten
+func (d *DedupBuffer) SetPromotion(enabled bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.promote = enabled
+	d.seen = make(map[dedupKey]dedupRecord)
+}
+
+// cloneEmpty returns a DedupBuffer with the same window and promotion setting as d,
+// wrapping an empty clone of d's Buffer, or nil if that Buffer can't be cloned
+func (d *DedupBuffer) cloneEmpty() Buffer {
+	c, ok := d.Buffer.(emptyCloner)
+	if !ok {
+		return nil
+	}
+	b := c.cloneEmpty()
+	if b == nil {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	clone := NewDedupBuffer(b, d.window)
+	clone.promote = d.promote
+	return clone
+}
+
+// Write writes b at the default priority unless it is a duplicate
+func (d *DedupBuffer) Write(b []byte) (int, error) {
+	return d.PWrite(d.GetPriority(), b)
+}
+
+// PWrite writes b with priority p unless it is a duplicate. Dropped duplicates are
+// reported as successful writes
+func (d *DedupBuffer) PWrite(p LogPriority, b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	d.prune(now)
+
+	key := dedupKey{p: p, msg: string(b)}
+	if d.promote {
+		key.p = 0
+	}
+
+	rec, ok := d.seen[key]
+	promoted := ok && now.Sub(rec.t) < d.window
+	if promoted && !d.less(rec.p, p) {
+		return len(b), nil
+	}
+
+	n, err := d.Buffer.PWrite(p, b)
+	if err != nil {
+		// the buffered copy is kept, so the message isn't lost
+		return n, err
+	}
+	if promoted {
+		if rm, ok := d.Buffer.(remover); ok {
+			rm.removeEntry(rec.p, b)
+		}
+	}
+	d.seen[key] = dedupRecord{p: p, t: now}
+	return n, err
+}
+
+// PWriteBatch writes each of entries with priority p, dropping duplicates as PWrite
+// does, and returns the number of entries accepted, including dropped duplicates
+// It stops at the first error
+func (d *DedupBuffer) PWriteBatch(p LogPriority, entries [][]byte) (int, error) {
+	for k, b := range entries {
+		if _, err := d.PWrite(p, b); err != nil {
+			return k, err
+		}
+	}
+	return len(entries), nil
+}
+
+// less reports whether a is a lower priority than b, using the wrapped Buffer's
+// priority comparator if it has one
+func (d *DedupBuffer) less(a, b LogPriority) bool {
+	if pc, ok := d.Buffer.(priorityComparer); ok {
+		return pc.lessPriority(a, b)
+	}
+	return numericLess(a, b)
+}
+
+// prune forgets messages whose window has passed, at most once per window
+// The caller must hold d.lock
+func (d *DedupBuffer) prune(now time.Time) {
+	if now.Sub(d.pruned) < d.window {
+		return
+	}
+
+	for k, rec := range d.seen {
+		if now.Sub(rec.t) >= d.window {
+			delete(d.seen, k)
+		}
+	}
+	d.pruned = now
+}
+
+// PopEntry pops from the wrapped Buffer, preserving priority and write time if the
+// wrapped Buffer records them
+func (d *DedupBuffer) PopEntry() (Entry, error) {
+	return popEntry(d.Buffer)
+}
@@ -0,0 +1,124 @@
+package plog
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDedupBuffer runs subtests covering DedupBuffer usage
+func TestDedupBuffer(t *testing.T) {
+	t.Run("per priority", testDedupBufferPerPriority)
+	t.Run("promotion", testDedupBufferPromotion)
+	t.Run("promotion comparator", testDedupBufferPromotionComparator)
+	t.Run("promotion rejected", testDedupBufferPromotionRejected)
+	t.Run("window", testDedupBufferWindow)
+	t.Run("batch", testDedupBufferBatch)
+}
+
+func testDedupBufferBatch(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	d := NewDedupBuffer(rb, time.Hour)
+	n, err := d.PWriteBatch(Major, [][]byte{[]byte("nemo"), []byte("dory"), []byte("nemo")})
+	if err != nil || n != 3 {
+		t.Logf("unexpected batch result %d, %v\n", n, err)
+		t.Fail()
+	}
+
+	popWithExpected("dory", rb, false, t)
+	popWithExpected("nemo", rb, false, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+func testDedupBufferPerPriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	d := NewDedupBuffer(rb, time.Hour)
+	d.Write([]byte("nemo"))
+	d.Write([]byte("nemo"))
+	d.PWrite(Major, []byte("nemo"))
+
+	popWithExpected("Major nemo", rb, true, t)
+	popWithExpected("Minor nemo", rb, true, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testDedupBufferPromotion asserts that a higher priority duplicate replaces the
+// buffered copy, even when the copy is not the newest entry in its ring
+func testDedupBufferPromotion(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	d := NewDedupBuffer(rb, time.Hour)
+	d.SetPromotion(true)
+
+	d.Write([]byte("nemo"))
+	d.Write([]byte("dory"))
+	d.PWrite(Critical, []byte("nemo"))
+	d.PWrite(Major, []byte("nemo"))
+	d.Write([]byte("marlin"))
+
+	popWithExpected("Critical nemo", rb, true, t)
+	popWithExpected("Minor marlin", rb, true, t)
+	popWithExpected("Minor dory", rb, true, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testDedupBufferPromotionComparator uses syslog-style ordering, where smaller values
+// are more urgent, and asserts that promotion follows it
+func testDedupBufferPromotionComparator(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetPriorityComparator(func(a, b LogPriority) bool { return a > b })
+	d := NewDedupBuffer(rb, time.Hour)
+	d.SetPromotion(true)
+
+	d.Write([]byte("nemo"))
+	d.PWrite(Trivial, []byte("nemo"))
+	d.PWrite(Critical, []byte("nemo"))
+
+	popWithExpected("Trivial nemo", rb, true, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testDedupBufferWindow(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	d := NewDedupBuffer(rb, time.Millisecond)
+	d.Write([]byte("nemo"))
+	time.Sleep(2 * time.Millisecond)
+	d.Write([]byte("nemo"))
+
+	popWithExpected("nemo", rb, false, t)
+	popWithExpected("nemo", rb, false, t)
+}
+
+// testDedupBufferPromotionRejected asserts that the buffered copy of a message is kept
+// when the Buffer rejects its promoted copy
+func testDedupBufferPromotionRejected(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1)
+	rb.SetOverflowPolicy(RejectNewest)
+	d := NewDedupBuffer(rb, time.Hour)
+	d.SetPromotion(true)
+
+	rb.PWrite(Critical, []byte("dory"))
+	d.Write([]byte("nemo"))
+	if _, err := d.PWrite(Critical, []byte("nemo")); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+
+	popWithExpected("Critical dory", rb, true, t)
+	popWithExpected("Minor nemo", rb, true, t)
+	if _, err := d.PWrite(Critical, []byte("nemo")); err != nil {
+		t.Logf("unexpected write error: %v\n", err)
+		t.Fail()
+	}
+	popWithExpected("Critical nemo", rb, true, t)
+}
@@ -0,0 +1,145 @@
+package plog
+
+import (
+	"time"
+)
+
+// drainPoll is how often DrainMin subscriptions check the Buffer for entries that were
+// written to it directly rather than through the Logger
+const drainPoll = 100 * time.Millisecond
+
+// DrainMin returns a channel that receives entries with priority min or higher as they
+// are printed. Entries are popped from the Buffer before they are sent, so each entry
+// is delivered at most once: with several subscriptions, an entry goes to exactly one
+// of the subscriptions whose threshold it meets, and which one is unspecified. Entries
+// below every threshold stay buffered
+// Entries printed through the Logger are delivered as soon as they're stored. Entries
+// written to the Buffer directly are noticed within drainPoll. The subscription lasts
+// until StopDrain is called with the returned channel or the Logger is closed, either
+// of which closes the channel
+// DrainMin requires a Buffer that implements MinPopper, such as RingBuffer; for other
+// Buffers, and once the Logger is closed, the returned channel is already closed
+func (l *Logger) DrainMin(min LogPriority) <-chan Entry {
+	ch := make(chan Entry)
+	if _, ok := l.GetBuffer().(MinPopper); !ok {
+		close(ch)
+		return ch
+	}
+
+	// the subscription counts as a background flusher, so Close waits for it
+	l.sessLock.RLock()
+	defer l.sessLock.RUnlock()
+	if l.closed.Load() {
+		close(ch)
+		return ch
+	}
+
+	stop := make(chan struct{})
+	l.drainLock.Lock()
+	if l.drains == nil {
+		l.drains = make(map[<-chan Entry]chan struct{})
+	}
+	l.drains[ch] = stop
+	l.drainLock.Unlock()
+
+	l.flushers.Add(1)
+	go l.drain(min, ch, stop)
+	return ch
+}
+
+// StopDrain ends the DrainMin subscription that returned ch and closes ch. An entry
+// that was popped for the subscription but not yet received is put back in the
+// Buffer with its write time, so it can be flushed or drained by another subscription
+func (l *Logger) StopDrain(ch <-chan Entry) {
+	l.drainLock.Lock()
+	defer l.drainLock.Unlock()
+
+	if stop, ok := l.drains[ch]; ok {
+		close(stop)
+		delete(l.drains, ch)
+	}
+}
+
+// drain pops entries with priority min or higher and sends them on ch until stop or
+// l.done is closed
+func (l *Logger) drain(min LogPriority, ch chan Entry, stop <-chan struct{}) {
+	defer l.flushers.Done()
+	defer close(ch)
+	defer func() {
+		l.drainLock.Lock()
+		defer l.drainLock.Unlock()
+
+		// only removes the subscription if StopDrain hasn't already
+		if l.drains[ch] == stop {
+			delete(l.drains, ch)
+		}
+	}()
+
+	timer := time.NewTimer(drainPoll)
+	defer timer.Stop()
+
+	for {
+		// wait on the notification from before popping, so a store that happens
+		// in between isn't missed
+		wait := l.drainWait()
+
+		l.bufLock.RLock()
+		var e Entry
+		err := ErrBufferEmpty
+		if mp, ok := l.buf.(MinPopper); ok {
+			e, err = mp.PopMin(min)
+		}
+		l.bufLock.RUnlock()
+
+		if err == nil {
+			select {
+			case ch <- e:
+				continue
+			case <-stop:
+			case <-l.done:
+			}
+			l.restore(e)
+			return
+		}
+
+		timer.Reset(drainPoll)
+		select {
+		case <-wait:
+		case <-timer.C:
+		case <-stop:
+			return
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// restore puts e, which was popped but not delivered, back in the Buffer
+func (l *Logger) restore(e Entry) {
+	l.bufLock.RLock()
+	defer l.bufLock.RUnlock()
+
+	writeEntry(l.buf, takenEntry{Entry: e})
+}
+
+// drainWait returns a channel that is closed the next time the Logger stores entries
+func (l *Logger) drainWait() <-chan struct{} {
+	l.drainLock.Lock()
+	defer l.drainLock.Unlock()
+
+	if l.drainNotify == nil {
+		l.drainNotify = make(chan struct{})
+	}
+	return l.drainNotify
+}
+
+// signalDrains wakes every DrainMin subscription
+func (l *Logger) signalDrains() {
+	l.drainLock.Lock()
+	defer l.drainLock.Unlock()
+
+	if l.drainNotify != nil {
+		close(l.drainNotify)
+		l.drainNotify = nil
+	}
+}
@@ -0,0 +1,150 @@
+package plog
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDrainMin runs subtests covering DrainMin subscriptions
+func TestDrainMin(t *testing.T) {
+	t.Run("threshold", testDrainMinThreshold)
+	t.Run("fan out", testDrainMinFanOut)
+	t.Run("unsupported", testDrainMinUnsupported)
+	t.Run("stop", testDrainMinStop)
+}
+
+func testDrainMinThreshold(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	ch := l.DrainMin(Critical)
+	defer l.StopDrain(ch)
+
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+	receiveWithExpected("critical0", ch, t)
+	popWithExpected("minor0", rb, false, t)
+}
+
+// testDrainMinFanOut asserts that each entry is delivered to exactly one subscription
+// whose threshold it meets, and that StopDrain closes the channel
+func testDrainMinFanOut(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	major := l.DrainMin(Major)
+	critical := l.DrainMin(Critical)
+
+	l.Print(Major, "major0")
+	receiveWithExpected("major0", major, t)
+
+	l.Print(Critical, "critical0")
+	select {
+	case e := <-major:
+		receiveNone(critical, t)
+		if e.Message != "critical0" {
+			t.Logf("unexpected entry %q\n", e.Message)
+			t.Fail()
+		}
+	case e := <-critical:
+		receiveNone(major, t)
+		if e.Message != "critical0" {
+			t.Logf("unexpected entry %q\n", e.Message)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Log("timed out waiting for critical0")
+		t.Fail()
+	}
+
+	l.StopDrain(major)
+	l.StopDrain(critical)
+	for _, ch := range []<-chan Entry{major, critical} {
+		select {
+		case _, ok := <-ch:
+			if ok {
+				t.Log("expected closed channel")
+				t.Fail()
+			}
+		case <-time.After(time.Second):
+			t.Log("timed out waiting for channel to close")
+			t.Fail()
+		}
+	}
+}
+
+func testDrainMinUnsupported(t *testing.T) {
+	l := NewLogger(NewSliceBuffer(Minor, 0))
+	if _, ok := <-l.DrainMin(Trivial); ok {
+		t.Log("expected closed channel")
+		t.Fail()
+	}
+}
+
+// testDrainMinStop asserts that an entry popped for a subscription but never
+// received is put back when the subscription is stopped, and that Close ends
+// subscriptions before returning
+func testDrainMinStop(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	ch := l.DrainMin(Critical)
+	l.Print(Critical, "critical0")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rb.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	l.StopDrain(ch)
+	closedWithExpected(ch, t)
+	popWithExpected("critical0", rb, false, t)
+
+	ch = l.DrainMin(Critical)
+	l.Close()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Log("expected closed channel, received an entry\n")
+			t.Fail()
+		}
+	default:
+		t.Log("subscription still running after Close returned\n")
+		t.Fail()
+	}
+	closedWithExpected(l.DrainMin(Critical), t)
+}
+
+// closedWithExpected asserts that ch is closed without receiving an entry
+func closedWithExpected(ch <-chan Entry, t *testing.T) {
+	select {
+	case e, ok := <-ch:
+		if ok {
+			t.Logf("expected closed channel, got %q\n", e.Message)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Log("timed out waiting for channel to close")
+		t.Fail()
+	}
+}
+
+// receiveWithExpected receives from ch and asserts that the entry's message matches
+// expected
+func receiveWithExpected(expected string, ch <-chan Entry, t *testing.T) {
+	select {
+	case e := <-ch:
+		if e.Message != expected {
+			t.Logf("%q != %q\n", e.Message, expected)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Logf("timed out waiting for %q\n", expected)
+		t.Fail()
+	}
+}
+
+// receiveNone asserts that nothing is received from ch for a short time
+func receiveNone(ch <-chan Entry, t *testing.T) {
+	select {
+	case e := <-ch:
+		t.Logf("unexpected entry %q\n", e.Message)
+		t.Fail()
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,153 @@
+package plog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+)
+
+// Decoder reads entries written by the matching Encoder
+// Decode returns io.EOF when r holds no more entries, and io.ErrUnexpectedEOF if r
+// ends partway through an entry
+type Decoder interface {
+	Decode(r *bufio.Reader) (Entry, error)
+}
+
+// Encoder serializes entries for Buffers that store them outside of memory, such as
+// FileBuffer. An Encoder must be able to decode everything it encodes
+type Encoder interface {
+	Encode(w io.Writer, e Entry) error
+	Decoder
+}
+
+// LengthPrefixEncoder is the default Encoder. It writes each entry's priority, time,
+// sequence number, message, and fields as varints and length-prefixed bytes, so
+// messages containing newlines or other delimiters are stored safely
+type LengthPrefixEncoder struct{}
+
+// Encode writes e to w in a single call to w.Write
+func (LengthPrefixEncoder) Encode(w io.Writer, e Entry) error {
+	var t int64
+	if !e.Time.IsZero() {
+		t = e.Time.UnixNano()
+	}
+
+	buf := binary.AppendVarint(nil, int64(e.Priority))
+	buf = binary.AppendVarint(buf, t)
+	buf = binary.AppendUvarint(buf, e.Seq)
+	buf = appendString(buf, e.Message)
+
+	// sort the keys so that equal entries always encode identically
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendString(buf, k)
+		buf = appendString(buf, e.Fields[k])
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendString appends the length of s followed by s to buf
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Decode reads the next entry from r
+func (LengthPrefixEncoder) Decode(r *bufio.Reader) (Entry, error) {
+	p, err := binary.ReadVarint(r)
+	if err != nil {
+		// io.EOF here means r ended cleanly between entries
+		return Entry{}, err
+	}
+
+	d := streamDecoder{r: r}
+	e := Entry{
+		Priority: LogPriority(p),
+	}
+	if t := d.varint(); t != 0 {
+		e.Time = time.Unix(0, t)
+	}
+	e.Seq = d.uvarint()
+	e.Message = d.string()
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		e.Fields = make(map[string]string)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.string()
+			e.Fields[k] = d.string()
+		}
+	}
+	if d.err != nil {
+		return Entry{}, d.err
+	}
+	return e, nil
+}
+
+// streamDecoder reads the values written by LengthPrefixEncoder after an entry's
+// first byte, recording the first error. Reaching the end of r is always reported as
+// io.ErrUnexpectedEOF
+type streamDecoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *streamDecoder) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	d.err = err
+}
+
+func (d *streamDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *streamDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+// string reads a length followed by that many bytes
+// The bytes are copied as they're read rather than allocated up front, so a corrupt
+// length fails with io.ErrUnexpectedEOF instead of exhausting memory
+func (d *streamDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if m, err := io.CopyN(&buf, d.r, int64(n)); uint64(m) != n {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		d.fail(err)
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,43 @@
+package plog
+
+import (
+	"time"
+)
+
+// Entry is a single log along with the information recorded when it was written
+type Entry struct {
+	Message  string
+	Priority LogPriority
+	Time     time.Time
+	Fields   map[string]string
+
+	// Seq is the sequence number the entry was written with, if the Buffer assigns
+	// them. RingBuffer numbers every write starting from 1
+	Seq uint64
+}
+
+// EntryPopper is implemented by Buffers that can return their entries along with the
+// priority and time they were written at, rather than only as strings
+type EntryPopper interface {
+	PopEntry() (Entry, error)
+}
+
+// entry is a single log stored in a RingBuffer
+type entry struct {
+	data   []byte
+	p      LogPriority
+	t      time.Time
+	fields map[string]string
+	seq    uint64
+}
+
+// export returns e as an Entry
+func (e *entry) export() Entry {
+	return Entry{
+		Message:  string(e.data),
+		Priority: e.p,
+		Time:     e.t,
+		Fields:   e.fields,
+		Seq:      e.seq,
+	}
+}
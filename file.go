@@ -0,0 +1,371 @@
+package plog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileBuffer stores entries in a file so that they survive restarts. Entries are
+// popped in the same order as RingBuffer: highest priority first, newest first within
+// a priority. FileBuffer never overwrites entries and is unbounded
+//
+// Writes append the encoded entry to the file, and Pop appends a record of the popped
+// entry, so neither rewrites the file. Once the popped records outnumber the stored
+// entries, the file is compacted by writing the stored entries to a temporary file
+// and renaming it over the original, so a crash never loses entries. The entries are
+// also kept in memory, so FileBuffer suits buffers that are flushed regularly rather
+// than ones that grow without limit
+//
+// Pop records are encoded as entries whose message starts with popMarker, so messages
+// starting with it can't be written
+type FileBuffer struct {
+	p       LogPriority
+	enc     Encoder
+	path    string
+	f       *os.File
+	entries []Entry
+	pos     []int // position in the file of each of entries, counting entry records
+	records int   // number of entry records in the file
+	popped  int   // number of pop records in the file
+	err     error // first compaction error since the previous Sync
+	seq     uint64
+	lock    *sync.Mutex
+}
+
+// popMarker prefixes the message of a pop record, which is followed by the position
+// of the popped entry
+const popMarker = "\x00plog:popped:"
+
+// minCompact is the number of pop records a FileBuffer's file holds before it is
+// compacted
+const minCompact = 64
+
+// errPopMarker is returned when writing a message that starts with popMarker
+var errPopMarker = errors.New("plog: FileBuffer messages can't start with the reserved pop marker")
+
+// NewFileBuffer opens or creates the file at path and returns a FileBuffer with
+// default priority p that stores its entries there, encoded with enc. If enc is nil,
+// LengthPrefixEncoder is used. Entries already in the file are loaded, so the file
+// must have been written with the same Encoder. If the last record was cut short, such
+// as by a crash while appending it, the file is truncated to the records before it
+// Other decoding errors are returned
+func NewFileBuffer(path string, p LogPriority, enc Encoder) (*FileBuffer, error) {
+	if enc == nil {
+		enc = LengthPrefixEncoder{}
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fb := &FileBuffer{
+		p:    p,
+		enc:  enc,
+		path: path,
+		f:    f,
+		lock: &sync.Mutex{},
+	}
+
+	var entries []Entry
+	removed := make(map[int]bool)
+	cr := &countingReader{r: f}
+	r := bufio.NewReader(cr)
+	for {
+		offset := cr.n - int64(r.Buffered())
+		e, err := enc.Decode(r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// a crash while appending leaves a partial last record, which is dropped
+			// so later records aren't appended after it
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("plog: truncating %s: %w", path, err)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("plog: reading %s: %w", path, err)
+		}
+
+		if pos, ok := strings.CutPrefix(e.Message, popMarker); ok {
+			k, err := strconv.Atoi(pos)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("plog: reading %s: invalid pop record %q", path, pos)
+			}
+			removed[k] = true
+			fb.popped++
+			continue
+		}
+		entries = append(entries, e)
+		fb.seq = max(fb.seq, e.Seq)
+	}
+
+	for k, e := range entries {
+		if !removed[k] {
+			fb.entries = append(fb.entries, e)
+			fb.pos = append(fb.pos, k)
+		}
+	}
+	fb.records = len(entries)
+	return fb, nil
+}
+
+// GetPriority returns the FileBuffer's default priority
+func (fb *FileBuffer) GetPriority() LogPriority {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	return fb.p
+}
+
+// SetPriority sets the FileBuffer's default priority
+func (fb *FileBuffer) SetPriority(p LogPriority) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	fb.p = p
+}
+
+// Available always returns -1, as a FileBuffer is unbounded
+func (fb *FileBuffer) Available() int {
+	return -1
+}
+
+// Len returns the number of entries in the FileBuffer
+func (fb *FileBuffer) Len() int {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	return len(fb.entries)
+}
+
+// Write writes b at the default priority
+func (fb *FileBuffer) Write(b []byte) (int, error) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	if err := fb.pwrite(fb.p, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// PWrite writes b with priority p
+func (fb *FileBuffer) PWrite(p LogPriority, b []byte) (int, error) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	if err := fb.pwrite(p, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// PWriteBatch writes each of entries with priority p and returns the number stored
+// If writing to the file fails, the remaining entries are not written
+func (fb *FileBuffer) PWriteBatch(p LogPriority, entries [][]byte) (int, error) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	for k, b := range entries {
+		if err := fb.pwrite(p, b); err != nil {
+			return k, err
+		}
+	}
+	return len(entries), nil
+}
+
+// pwrite appends b to the file with priority p and, if that succeeds, stores it
+// The caller must hold fb.lock
+func (fb *FileBuffer) pwrite(p LogPriority, b []byte) error {
+	if bytes.HasPrefix(b, []byte(popMarker)) {
+		return errPopMarker
+	}
+
+	e := Entry{
+		Message:  string(b),
+		Priority: p,
+		Time:     time.Now(),
+		Seq:      fb.seq + 1,
+	}
+	if err := fb.enc.Encode(fb.f, e); err != nil {
+		return err
+	}
+
+	fb.seq++
+	fb.entries = append(fb.entries, e)
+	fb.pos = append(fb.pos, fb.records)
+	fb.records++
+	return nil
+}
+
+// Pop removes and returns the highest priority, newest entry
+func (fb *FileBuffer) Pop(priPrefix bool) (string, error) {
+	e, err := fb.PopEntry()
+	if err != nil {
+		return "", err
+	}
+
+	if priPrefix {
+		return fmt.Sprintf("%s %s", PriorityString(e.Priority), e.Message), nil
+	}
+	return e.Message, nil
+}
+
+// PopEntry removes and returns the highest priority, newest entry along with the
+// priority and time it was written at
+// If the pop can't be recorded in the file, PopEntry returns the error and keeps the
+// entry
+func (fb *FileBuffer) PopEntry() (Entry, error) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	if len(fb.entries) == 0 {
+		return Entry{}, ErrBufferEmpty
+	}
+
+	j := len(fb.entries) - 1
+	for k := j - 1; k >= 0; k-- {
+		if fb.entries[k].Priority > fb.entries[j].Priority {
+			j = k
+		}
+	}
+
+	e := fb.entries[j]
+	record := Entry{Message: popMarker + strconv.Itoa(fb.pos[j])}
+	if err := fb.enc.Encode(fb.f, record); err != nil {
+		return Entry{}, err
+	}
+	fb.popped++
+	fb.entries = append(fb.entries[:j], fb.entries[j+1:]...)
+	fb.pos = append(fb.pos[:j], fb.pos[j+1:]...)
+
+	// the pop is already recorded, so a failed compaction is retried by the next pop
+	// and its error is returned by Sync
+	if fb.popped >= minCompact && fb.popped > len(fb.entries) {
+		if err := fb.compact(); err != nil && fb.err == nil {
+			fb.err = err
+		}
+	}
+	return e, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+// compact replaces the file with one holding only the stored entries. The new file
+// is written, synced, and opened for appending under a temporary name, then renamed
+// over the original, so either file holds every stored entry if the process stops
+// partway through, and the FileBuffer keeps writing to the original if it fails
+// The caller must hold fb.lock
+func (fb *FileBuffer) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(fb.path), filepath.Base(fb.path)+".compact*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range fb.entries {
+		if err := fb.enc.Encode(w, e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(tmp.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fb.path); err != nil {
+		f.Close()
+		return err
+	}
+	fb.f.Close()
+	fb.f = f
+	for k := range fb.pos {
+		fb.pos[k] = k
+	}
+	fb.records = len(fb.entries)
+	fb.popped = 0
+	return nil
+}
+
+// ForEachEntry calls fn for each entry in the FileBuffer, in the same order Pop would
+// return them, without removing any. Traversal stops early if fn returns false
+// fn is called while the FileBuffer is locked, so it must not call FileBuffer methods
+func (fb *FileBuffer) ForEachEntry(fn func(e Entry) bool) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	// newest first, then stable sorted so higher priorities come first
+	entries := make([]Entry, len(fb.entries))
+	for k, e := range fb.entries {
+		entries[len(entries)-1-k] = e
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Priority > entries[j].Priority
+	})
+	for _, e := range entries {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// Sync implements Syncer by committing the file to stable storage. It also returns
+// the first error from compacting the file since the previous call, if any
+func (fb *FileBuffer) Sync() error {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	err := fb.err
+	fb.err = nil
+	if syncErr := fb.f.Sync(); syncErr != nil {
+		return syncErr
+	}
+	return err
+}
+
+// Close closes the file. The FileBuffer must not be used afterwards
+func (fb *FileBuffer) Close() error {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+
+	return fb.f.Close()
+}
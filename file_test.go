@@ -0,0 +1,192 @@
+package plog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileBuffer runs subtests covering FileBuffer persistence and encoding
+func TestFileBuffer(t *testing.T) {
+	t.Run("reopen", testFileBufferReopen)
+	t.Run("truncated", testFileBufferTruncated)
+	t.Run("encoder", testFileBufferEncoder)
+	t.Run("compact", testFileBufferCompact)
+}
+
+// testFileBufferReopen asserts that entries, including ones with embedded newlines,
+// survive reopening the file and that popped entries stay popped
+func testFileBufferReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plog")
+	fb, err := NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	fb.Write([]byte("minor0\nminor0"))
+	fb.PWrite(Critical, []byte("critical0"))
+	fb.Write([]byte("minor1"))
+	if s, err := fb.Pop(true); err != nil || s != "Critical critical0" {
+		t.Logf("err: %v || %q != %q\n", err, s, "Critical critical0")
+		t.Fail()
+	}
+	fb.Close()
+
+	fb, err = NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected reopen error: %v\n", err)
+	}
+	defer fb.Close()
+	fb.Write([]byte("minor2"))
+
+	for _, expected := range []string{"minor2", "minor1", "minor0\nminor0"} {
+		if s, err := fb.Pop(false); err != nil || s != expected {
+			t.Logf("err: %v || %q != %q\n", err, s, expected)
+			t.Fail()
+		}
+	}
+	if _, err := fb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testFileBufferTruncated asserts that a partial last record, as left by a crash
+// while appending, is truncated when the file is opened, and that writes and pops
+// still work afterward
+func testFileBufferTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plog")
+	fb, err := NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	fb.Write([]byte("minor0"))
+	fb.Close()
+
+	complete, _ := os.ReadFile(path)
+	fb, err = NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected reopen error: %v\n", err)
+	}
+	fb.Write([]byte("minor1"))
+	fb.Close()
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-2], 0644)
+	fb, err = NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected error opening a truncated file: %v\n", err)
+	}
+	defer fb.Close()
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, complete) {
+		t.Logf("expected the partial record to be truncated, got %d bytes, want %d\n", len(data), len(complete))
+		t.Fail()
+	}
+
+	fb.Write([]byte("minor2"))
+	for _, expected := range []string{"minor2", "minor0"} {
+		if s, err := fb.Pop(false); err != nil || s != expected {
+			t.Logf("err: %v || %q != %q\n", err, s, expected)
+			t.Fail()
+		}
+	}
+}
+
+// lineEncoder stores each entry as a line of text holding its priority and message
+type lineEncoder struct{}
+
+func (lineEncoder) Encode(w io.Writer, e Entry) error {
+	_, err := fmt.Fprintf(w, "%d %s\n", e.Priority, e.Message)
+	return err
+}
+
+func (lineEncoder) Decode(r *bufio.Reader) (Entry, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var e Entry
+	if _, err := fmt.Sscanf(line, "%d %s", &e.Priority, &e.Message); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func testFileBufferEncoder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plog")
+	fb, err := NewFileBuffer(path, Minor, lineEncoder{})
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	defer fb.Close()
+	fb.Write([]byte("minor0"))
+	fb.PWrite(Major, []byte("major0"))
+
+	if data, _ := os.ReadFile(path); string(data) != "1 minor0\n2 major0\n" {
+		t.Logf("unexpected file contents %q\n", data)
+		t.Fail()
+	}
+}
+
+// testFileBufferCompact asserts that pops are kept across reopening both before and
+// after the file is compacted, and that compaction shrinks the file
+func testFileBufferCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plog")
+	fb, err := NewFileBuffer(path, Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	for i := 0; i < 2*minCompact; i++ {
+		fb.Write([]byte(fmt.Sprintf("minor%d", i)))
+	}
+	if _, err := fb.Write([]byte(popMarker + "0")); err != errPopMarker {
+		t.Logf("expected %v, got %v\n", errPopMarker, err)
+		t.Fail()
+	}
+
+	popTo := func(n int) {
+		for fb.Len() > n {
+			if _, err := fb.Pop(false); err != nil {
+				t.Fatalf("unexpected pop error: %v\n", err)
+			}
+		}
+	}
+	reopen := func() {
+		fb.Close()
+		if fb, err = NewFileBuffer(path, Minor, nil); err != nil {
+			t.Fatalf("unexpected reopen error: %v\n", err)
+		}
+	}
+
+	popTo(2*minCompact - 3)
+	reopen()
+	if fb.Len() != 2*minCompact-3 {
+		t.Logf("%d != %d\n", fb.Len(), 2*minCompact-3)
+		t.Fail()
+	}
+	before, _ := os.Stat(path)
+
+	popTo(2)
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Logf("expected compacted file, %d >= %d\n", after.Size(), before.Size())
+		t.Fail()
+	}
+	reopen()
+	defer fb.Close()
+
+	for _, expected := range []string{"minor1", "minor0"} {
+		if s, err := fb.Pop(false); err != nil || s != expected {
+			t.Logf("err: %v || %q != %q\n", err, s, expected)
+			t.Fail()
+		}
+	}
+	if _, err := fb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
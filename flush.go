@@ -0,0 +1,691 @@
+package plog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SetFormat sets the template applied to each entry when the Logger is flushed
+// The template may contain the placeholders {priority}, {msg}, and {time}, which are
+// replaced by the entry's priority string, its message (without a trailing newline),
+// and its write time in RFC 3339 format. An empty template writes the message alone
+//
+// Priority and time are only available from Buffers that implement EntryPopper, such
+// as RingBuffer. For other Buffers, the Buffer's default priority and the zero time
+// are used
+func (l *Logger) SetFormat(tmpl string) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.format = tmpl
+}
+
+// SetBinarySafe sets whether messages formatted as text, by FlushTo and the output
+// writer among others, have bytes that could garble a terminal escaped. Invalid UTF-8
+// is written as \xNN and control and other non-printable characters, except newline
+// and tab, as Go escape sequences such as \x00 or \u200b. Backslashes aren't
+// escaped, so the result can't be unescaped unambiguously. Flushes that format
+// entries themselves, such as FlushWith and FlushProto, aren't affected
+// It's disabled by default
+func (l *Logger) SetBinarySafe(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.binarySafe = enabled
+}
+
+// escapeBinary returns s with invalid UTF-8 and non-printable characters other than
+// newline and tab escaped
+func escapeBinary(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(s[i:], string(utf8.RuneError)):
+			// an invalid byte, rather than an encoded U+FFFD
+			fmt.Fprintf(&sb, `\x%02x`, s[i])
+		case r == '\n' || r == '\t' || strconv.IsPrint(r):
+			sb.WriteRune(r)
+		default:
+			q := strconv.QuoteRune(r)
+			sb.WriteString(q[1 : len(q)-1])
+		}
+	}
+	return sb.String()
+}
+
+// FlushTo pops every entry from the Logger's Buffer, formats it according to the
+// Logger's format, and writes it to w followed by a newline. It returns the number
+// of bytes written and the first write error encountered, if any, or the error from
+// popping if the Buffer fails to pop for a reason other than being empty
+// FlushTo stops at the first failed write. The entry that failed is passed to the
+// dead letter hook, if one is set, and the remaining entries stay buffered
+// Entries are written in the order set by SetFlushOrder
+func (l *Logger) FlushTo(w io.Writer) (int, error) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	n, failed, err := l.flushTo(w, l.formatLine)
+	if err != nil {
+		l.queueDeadLetter(failed)
+	}
+	return n, err
+}
+
+// FlushWith flushes the Logger's Buffer to w like FlushTo, but formats each entry with
+// format rather than the Logger's format, so each destination can use its own format
+// The bytes returned by format are written as they are; no newline is added. If
+// format panics, the flush stops and the panic is returned as an error, with the entry
+// being formatted handled like one whose write failed
+func (l *Logger) FlushWith(w io.Writer, format func(Entry) []byte) (int, error) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	n, failed, err := l.flushTo(w, func(e Entry) (line []byte, err error) {
+		err = safeCall(func() { line = format(e) })
+		if line == nil && err == nil {
+			line = []byte{}
+		}
+		return line, err
+	})
+	if err != nil {
+		l.queueDeadLetter(failed)
+	}
+	return n, err
+}
+
+// FlushBuffered flushes the Logger's Buffer to w like FlushTo, but writes through a
+// bufio.Writer of bufSize bytes, so w receives fewer, larger writes. The bufio.Writer
+// is flushed before FlushBuffered returns. It returns the number of bytes written to
+// w, which leaves out bytes still buffered when a write to w failed
+// Entries whose bytes were buffered but never reached w aren't passed to the dead
+// letter hook, as they've already been popped
+func (l *Logger) FlushBuffered(w io.Writer, bufSize int) (int, error) {
+	bw := bufio.NewWriterSize(w, bufSize)
+	n, err := l.FlushTo(bw)
+	if flushErr := bw.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	return n - bw.Buffered(), err
+}
+
+// FlushCollect flushes the Logger's Buffer to w like FlushTo and also returns the
+// formatted lines that were written, in order, including any drop markers. A line
+// whose write failed isn't included
+func (l *Logger) FlushCollect(w io.Writer) ([]string, int, error) {
+	cw := &collectWriter{w: w}
+	n, err := l.FlushTo(cw)
+	return cw.lines, n, err
+}
+
+// collectWriter records each write that its io.Writer accepts in full
+type collectWriter struct {
+	w     io.Writer
+	lines []string
+}
+
+func (w *collectWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err == nil && n == len(b) {
+		w.lines = append(w.lines, string(b))
+	}
+	return n, err
+}
+
+// FlushToStdLog pops every entry from the Logger's Buffer, formats it according to
+// the Logger's format, and passes it to std.Output, so that std's prefix, flags, and
+// output apply. As with FlushTo, the first failed output stops the flush and its entry
+// is passed to the dead letter hook
+func (l *Logger) FlushToStdLog(std *log.Logger) error {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	_, failed, err := l.flushTo(stdLogWriter{std}, l.formatLine)
+	if err != nil {
+		l.queueDeadLetter(failed)
+	}
+	return err
+}
+
+// stdLogWriter passes each write to a *log.Logger as a single entry
+type stdLogWriter struct {
+	std *log.Logger
+}
+
+func (w stdLogWriter) Write(b []byte) (int, error) {
+	if err := w.std.Output(2, string(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// formatLine formats e according to the Logger's format, for use with flushTo
+func (l *Logger) formatLine(e Entry) ([]byte, error) {
+	return l.formatEntry(e), nil
+}
+
+// flushTo implements FlushTo, formatting each entry with format. It returns the
+// messages of the entries that were popped but not written along with the error
+// Entries that format returns a nil line for are put back in the Buffer once the
+// flush ends, oldest first, and drop markers it returns a nil line for are skipped
+// The caller must hold bufLock for reading
+func (l *Logger) flushTo(w io.Writer, format func(Entry) ([]byte, error)) (n int, failed []string, err error) {
+	var entries int
+	defer l.recordFlush(time.Now(), &n, &entries)
+
+	var kept []Entry
+	defer func() {
+		sort.SliceStable(kept, func(i, j int) bool {
+			if !kept[i].Time.Equal(kept[j].Time) {
+				return kept[i].Time.Before(kept[j].Time)
+			}
+			return kept[i].Seq < kept[j].Seq
+		})
+		for _, e := range kept {
+			if restoreErr := writeEntry(l.buf, takenEntry{Entry: e}); restoreErr != nil {
+				failed = append(failed, e.Message)
+				if err == nil {
+					err = restoreErr
+				}
+			}
+		}
+	}()
+
+	l.confLock.RLock()
+	order, dropMarks, transform := l.flushOrder, l.dropMarks, l.transform
+	l.confLock.RUnlock()
+
+	if dc, ok := l.buf.(DropCounter); ok && dropMarks {
+		for _, e := range dropMarkers(dc.TakeDropped()) {
+			line, err := format(e)
+			if err != nil {
+				return n, nil, err
+			}
+			if line == nil {
+				continue
+			}
+			m, err := w.Write(line)
+			n += m
+			if err != nil {
+				return n, nil, err
+			}
+		}
+	}
+
+	next := func() (Entry, error) { return popEntry(l.buf) }
+	if order == ChronologicalOrder {
+		var popped []Entry
+		var popErr error
+		for {
+			var e Entry
+			if e, popErr = popEntry(l.buf); popErr != nil {
+				break
+			}
+			popped = append(popped, e)
+		}
+		sort.SliceStable(popped, func(i, j int) bool {
+			if !popped[i].Time.Equal(popped[j].Time) {
+				return popped[i].Time.Before(popped[j].Time)
+			}
+			return popped[i].Seq < popped[j].Seq
+		})
+
+		next = func() (Entry, error) {
+			if len(popped) == 0 {
+				return Entry{}, popErr
+			}
+			e := popped[0]
+			popped = popped[1:]
+			return e, nil
+		}
+		defer func() {
+			for _, e := range popped {
+				failed = append(failed, e.Message)
+			}
+		}()
+	}
+
+	for {
+		e, popErr := next()
+		if popErr == ErrBufferEmpty {
+			return n, nil, nil
+		}
+		if popErr != nil {
+			return n, nil, popErr
+		}
+		orig := e
+		e, ok := transformEntry(transform, e)
+		if !ok {
+			continue
+		}
+
+		line, err := format(e)
+		if err != nil {
+			return n, []string{e.Message}, err
+		}
+		if line == nil {
+			kept = append(kept, orig)
+			continue
+		}
+		m, err := w.Write(line)
+		n += m
+		if err != nil {
+			return n, []string{e.Message}, err
+		}
+		entries++
+	}
+}
+
+// SetFlushTransform sets a function applied to each entry as a flush pops it, before
+// it's routed and formatted, so entries can be adapted for their destination without
+// changing what's buffered. An entry transformed into one with an empty message is
+// dropped from the flush. The transform applies to every flush, including threshold
+// flushes, but not to drop markers or String. If the transform panics, the panic is
+// recovered and the entry is flushed unchanged. Passing nil removes it
+func (l *Logger) SetFlushTransform(fn func(Entry) Entry) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.transform = fn
+}
+
+// transformEntry applies fn to e and reports whether the result should be flushed
+// A nil fn, or one that panics, leaves e unchanged
+func transformEntry(fn func(Entry) Entry, e Entry) (Entry, bool) {
+	if fn == nil {
+		return e, true
+	}
+
+	var transformed Entry
+	if err := safeCall(func() { transformed = fn(e) }); err != nil {
+		return e, true
+	}
+	return transformed, transformed.Message != ""
+}
+
+// SetDropMarkers sets whether FlushTo, FlushAndReset, FlushWith, and Flush begin by
+// writing a marker entry, such as "[plog] dropped 37 Trivial entries", for each
+// priority that lost entries to overflow since the previous flush. Markers are
+// formatted like other entries, with the priority of the dropped entries, so gaps in
+// the log are visible in the log itself. Drop markers require a Buffer that implements DropCounter, such
+// as RingBuffer; for other Buffers they have no effect
+func (l *Logger) SetDropMarkers(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.dropMarks = enabled
+}
+
+// dropMarkers returns a marker entry for each priority in dropped, highest first
+func dropMarkers(dropped map[LogPriority]int) []Entry {
+	markers := make([]Entry, 0, len(dropped))
+	for p, n := range dropped {
+		markers = append(markers, Entry{
+			Message:  fmt.Sprintf("[plog] dropped %d %s entries", n, PriorityString(p)),
+			Priority: p,
+			Time:     time.Now(),
+		})
+	}
+	sort.Slice(markers, func(i, j int) bool {
+		return markers[i].Priority > markers[j].Priority
+	})
+	return markers
+}
+
+// entryEacher is implemented by Buffers that can visit their entries without removing
+// them, such as RingBuffer, SliceBuffer, and FileBuffer
+type entryEacher interface {
+	ForEachEntry(func(Entry) bool)
+}
+
+// String returns the entries in the Logger's Buffer, formatted according to the
+// Logger's format and each followed by a newline, without removing them. Entries are
+// listed in the order the Buffer's ForEachEntry visits them, which is the order Pop
+// returns them. For Buffers that can't be read without popping, String returns an
+// empty string
+func (l *Logger) String() string {
+	l.bufLock.RLock()
+	defer l.bufLock.RUnlock()
+
+	ee, ok := l.buf.(entryEacher)
+	if !ok {
+		return ""
+	}
+
+	var entries []Entry
+	ee.ForEachEntry(func(e Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+
+	var sb strings.Builder
+	for _, e := range entries {
+		sb.Write(l.formatEntry(e))
+	}
+	return sb.String()
+}
+
+// FlushOrder determines the order in which a Logger's flushes write entries
+type FlushOrder int
+
+const (
+	// PriorityOrder writes entries in the order the Buffer pops them, which for
+	// RingBuffer is highest priority and newest first
+	PriorityOrder FlushOrder = iota
+	// ChronologicalOrder writes entries oldest first, regardless of priority
+	ChronologicalOrder
+)
+
+// SetFlushOrder sets the order in which FlushTo, FlushAndReset, FlushWith, and Flush
+// write entries. ChronologicalOrder sorts entries by the time they were written, falling
+// back to their sequence number, so a flushed file reads as a timeline. The priority
+// remains available through the {priority} format placeholder. Sorting requires
+// popping every entry before writing any, so if a write fails, the entries after it
+// can't stay buffered; they are passed to the dead letter hook along with the failed
+// entry
+// Entries from Buffers that don't implement EntryPopper have no time, so they keep
+// the order the Buffer pops them in
+func (l *Logger) SetFlushOrder(order FlushOrder) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.flushOrder = order
+}
+
+// FlushStats describes a single flush of a Logger's Buffer
+type FlushStats struct {
+	Start    time.Time     // when the flush started
+	Duration time.Duration // how long the flush took
+	Bytes    int           // number of bytes written
+	Entries  int           // number of entries written
+}
+
+// LastFlushStats returns statistics for the most recent call to FlushTo,
+// FlushAndReset, FlushWith, or FlushRouted, or the zero FlushStats if the Logger
+// hasn't been flushed. Flushes made by the flush threshold are not recorded
+func (l *Logger) LastFlushStats() FlushStats {
+	l.confLock.RLock()
+	defer l.confLock.RUnlock()
+
+	return l.lastFlush
+}
+
+// recordFlush records the statistics for a flush that started at start and wrote n
+// bytes and entries entries. It is deferred by flushes, so n and entries are read
+// once the flush returns
+func (l *Logger) recordFlush(start time.Time, n, entries *int) {
+	stats := FlushStats{
+		Start:    start,
+		Duration: time.Since(start),
+		Bytes:    *n,
+		Entries:  *entries,
+	}
+
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.lastFlush = stats
+}
+
+// FlushRouted pops every entry from the Logger's Buffer and writes it, formatted like
+// FlushTo, to the writer routes maps its priority to, or to def if its priority has no
+// route. Entries with no route stay buffered when def is nil. Drop markers and the
+// flush order apply as they do to FlushTo, with markers routed by the priority of
+// the dropped entries. It returns the total number of bytes written and stops at the
+// first failed write, which is handled as it is by FlushTo
+// Routing by priority requires a Buffer that implements EntryPopper, such as
+// RingBuffer; for other Buffers every entry is routed by the Buffer's default priority
+func (l *Logger) FlushRouted(routes map[LogPriority]io.Writer, def io.Writer) (int, error) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	rw := &routeWriter{}
+	n, failed, err := l.flushTo(rw, func(e Entry) ([]byte, error) {
+		w, ok := routes[e.Priority]
+		if !ok {
+			w = def
+		}
+		if w == nil {
+			return nil, nil
+		}
+		rw.w = w
+		return l.formatEntry(e), nil
+	})
+	if err != nil {
+		l.queueDeadLetter(failed)
+	}
+	return n, err
+}
+
+// routeWriter writes to the writer chosen for the entry being flushed
+type routeWriter struct {
+	w io.Writer
+}
+
+func (rw *routeWriter) Write(b []byte) (int, error) {
+	return rw.w.Write(b)
+}
+
+// SetDestination sets the writer that Flush writes entries with priority p to
+// Passing a nil w removes the destination, so those entries go to the default
+// destination instead
+func (l *Logger) SetDestination(p LogPriority, w io.Writer) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	if w == nil {
+		delete(l.dests, p)
+		return
+	}
+	if l.dests == nil {
+		l.dests = make(map[LogPriority]io.Writer)
+	}
+	l.dests[p] = w
+}
+
+// SetDefaultDestination sets the writer that Flush writes entries to when their
+// priority has no destination. With no default, which is the default, such entries
+// stay buffered
+func (l *Logger) SetDefaultDestination(w io.Writer) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.destDef = w
+}
+
+// Flush behaves like FlushRouted with the destinations set by SetDestination and
+// SetDefaultDestination, so a Logger whose routing doesn't change can be flushed
+// without passing its routes on every call
+// If no destination is set, Flush returns ErrNoDestination and leaves the Buffer as
+// it is, rather than discarding every entry
+func (l *Logger) Flush() error {
+	l.confLock.RLock()
+	routes := make(map[LogPriority]io.Writer, len(l.dests))
+	for p, w := range l.dests {
+		routes[p] = w
+	}
+	def := l.destDef
+	l.confLock.RUnlock()
+
+	if len(routes) == 0 && def == nil {
+		return ErrNoDestination
+	}
+
+	_, err := l.FlushRouted(routes, def)
+	return err
+}
+
+// SetDeadLetter sets a hook that receives the messages of entries that were popped
+// for flushing but couldn't be written, such as to write them to a local fallback
+// A failed write is treated as permanent; the Logger doesn't retry it. The hook is
+// called after the flush stops, at most once per flush, once the Logger is done with
+// its Buffer, so the hook may print to the Logger or swap its Buffer. Passing nil
+// removes the hook
+func (l *Logger) SetDeadLetter(fn func(entries []string)) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.deadFn = fn
+}
+
+// queueDeadLetter holds entries for the dead letter hook until bufLock is released, so
+// the hook can use the Logger without deadlocking
+// The caller must hold bufLock for reading and release it with releaseBuf
+func (l *Logger) queueDeadLetter(entries []string) {
+	l.deadLock.Lock()
+	defer l.deadLock.Unlock()
+
+	l.deadQueue = append(l.deadQueue, entries...)
+}
+
+// releaseBuf releases bufLock for reading and passes the entries queued by
+// queueDeadLetter to the dead letter hook
+func (l *Logger) releaseBuf() {
+	l.bufLock.RUnlock()
+
+	l.deadLock.Lock()
+	entries := l.deadQueue
+	l.deadQueue = nil
+	l.deadLock.Unlock()
+	l.deadLetter(entries)
+}
+
+// deadLetter passes entries to the dead letter hook, if one is set
+func (l *Logger) deadLetter(entries []string) {
+	l.confLock.RLock()
+	fn := l.deadFn
+	l.confLock.RUnlock()
+
+	if fn == nil || len(entries) == 0 {
+		return
+	}
+	safeCall(func() { fn(entries) })
+}
+
+// FlushAndReset flushes the Logger's Buffer to w like FlushTo and guarantees that Pop
+// has nothing left to return afterward. If a write fails, the entries that haven't
+// been written are popped and discarded and the write error is returned, so
+// FlushAndReset is lossy on error. This suits periodic shipping where losing a failed
+// batch is acceptable. Whether or not a dead letter hook is set, sticky entries that
+// Pop doesn't return stay buffered, as they do with FlushTo
+// If a dead letter hook is set, the failed entry and the discarded entries are passed
+// to it together
+func (l *Logger) FlushAndReset(w io.Writer) (int, error) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	n, failed, err := l.flushTo(w, l.formatLine)
+	if err == nil {
+		return n, nil
+	}
+
+	dead := failed
+	for {
+		e, popErr := popEntry(l.buf)
+		if popErr != nil {
+			break
+		}
+		dead = append(dead, e.Message)
+	}
+	l.queueDeadLetter(dead)
+	return n, err
+}
+
+// SetFlushThreshold makes the Logger flush entries with priority p or higher to w as
+// soon as they're printed, while lower priority entries stay buffered until the next
+// full flush. Entries are formatted according to the Logger's format
+// Threshold flushing requires a Buffer that implements MinPopper, such as RingBuffer;
+// for other Buffers it has no effect. Passing a nil writer disables it
+func (l *Logger) SetFlushThreshold(p LogPriority, w io.Writer) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.flushP = p
+	l.flushW = w
+}
+
+// flushThreshold writes every buffered entry at or above the flush threshold to the
+// flush threshold writer. The caller must hold bufLock for reading and release it with
+// releaseBuf, so a failed entry reaches the dead letter hook
+func (l *Logger) flushThreshold() error {
+	l.confLock.RLock()
+	p, w, transform := l.flushP, l.flushW, l.transform
+	l.confLock.RUnlock()
+
+	mp, ok := l.buf.(MinPopper)
+	if w == nil || !ok {
+		return nil
+	}
+
+	for {
+		e, err := mp.PopMin(p)
+		if err != nil {
+			return nil
+		}
+		e, ok := transformEntry(transform, e)
+		if !ok {
+			continue
+		}
+		if _, err := w.Write(l.formatEntry(e)); err != nil {
+			l.queueDeadLetter([]string{e.Message})
+			return err
+		}
+	}
+}
+
+// popEntry pops the next entry from b, preserving its priority and write time if b
+// implements EntryPopper. Otherwise, b's default priority and the zero time are used
+func popEntry(b Buffer) (Entry, error) {
+	if ep, ok := b.(EntryPopper); ok {
+		return ep.PopEntry()
+	}
+
+	s, err := b.Pop(false)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Message:  s,
+		Priority: b.GetPriority(),
+	}, nil
+}
+
+// outputFormat is the template the output writer uses when the Logger has no format
+const outputFormat = "{priority}: {msg}"
+
+// formatEntry applies the Logger's format to e
+func (l *Logger) formatEntry(e Entry) []byte {
+	return l.formatEntryOr(e, "")
+}
+
+// formatEntryOr applies the Logger's format to e, or def if the Logger has no format
+// An empty template writes the message alone
+func (l *Logger) formatEntryOr(e Entry, def string) []byte {
+	l.confLock.RLock()
+	tmpl, binarySafe := l.format, l.binarySafe
+	l.confLock.RUnlock()
+
+	msg := strings.TrimSuffix(e.Message, "\n")
+	if binarySafe {
+		msg = escapeBinary(msg)
+	}
+	if tmpl == "" {
+		tmpl = def
+	}
+	if tmpl == "" {
+		return []byte(msg + "\n")
+	}
+
+	r := strings.NewReplacer(
+		"{priority}", PriorityString(e.Priority),
+		"{msg}", msg,
+		"{time}", e.Time.Format(time.RFC3339),
+	)
+	return []byte(r.Replace(tmpl) + "\n")
+}
@@ -0,0 +1,585 @@
+package plog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFlush runs subtests covering the Logger's flushing behavior
+func TestFlush(t *testing.T) {
+	t.Run("default format", testFlushDefaultFormat)
+	t.Run("template", testFlushTemplate)
+	t.Run("time", testFlushTime)
+	t.Run("output tee", testFlushOutputTee)
+	t.Run("output only", testFlushOutputOnly)
+	t.Run("threshold", testFlushThreshold)
+	t.Run("flush and reset", testFlushAndReset)
+	t.Run("dead letter", testFlushDeadLetter)
+	t.Run("dead letter reentrant", testFlushDeadLetterReentrant)
+	t.Run("routed", testFlushRouted)
+	t.Run("destinations", testFlushDestinations)
+	t.Run("stats", testFlushStats)
+	t.Run("fatal", testFlushFatal)
+	t.Run("chronological", testFlushChronological)
+	t.Run("drop markers", testFlushDropMarkers)
+	t.Run("string", testFlushString)
+	t.Run("flush with", testFlushWith)
+	t.Run("buffered", testFlushBuffered)
+	t.Run("collect", testFlushCollect)
+	t.Run("binary safe", testFlushBinarySafe)
+	t.Run("std log", testFlushStdLog)
+	t.Run("transform", testFlushTransform)
+}
+
+func testFlushWith(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	var out bytes.Buffer
+	n, err := l.FlushWith(&out, func(e Entry) []byte {
+		return []byte(fmt.Sprintf("%q,%d\n", e.Message, e.Priority))
+	})
+	expected := "\"critical0\",3\n\"minor0\",1\n"
+	if err != nil || out.String() != expected || n != len(expected) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, out.String(), expected, n)
+		t.Fail()
+	}
+
+	l.Print(Minor, "minor1")
+	l.Print(Minor, "minor2")
+	_, err = l.FlushWith(&out, func(e Entry) []byte { panic("nemo") })
+	if err == nil || !strings.Contains(err.Error(), "nemo") {
+		t.Logf("expected recovered panic error, got %v\n", err)
+		t.Fail()
+	}
+	flushWithExpected("Minor: minor1\n", l, t)
+}
+
+func testFlushStdLog(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	var out bytes.Buffer
+	std := log.New(&out, "[app] ", 0)
+	if err := l.FlushToStdLog(std); err != nil {
+		t.Logf("unexpected error: %v\n", err)
+		t.Fail()
+	}
+	expected := "[app] Critical: critical0\n[app] Minor: minor0\n"
+	if out.String() != expected {
+		t.Logf("%q != %q\n", out.String(), expected)
+		t.Fail()
+	}
+	flushWithExpected("", l, t)
+}
+
+// testFlushTransform asserts that the transform rewrites and drops flushed entries
+// without changing the buffered ones
+func testFlushTransform(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetFormat("{priority}: {msg}")
+	l.SetFlushTransform(func(e Entry) Entry {
+		if e.Priority < Major {
+			return Entry{}
+		}
+		e.Message += " host=reef"
+		return e
+	})
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	if s := l.String(); s != "Critical: critical0\nMinor: minor0\n" {
+		t.Logf("unexpected buffered entries %q\n", s)
+		t.Fail()
+	}
+	flushWithExpected("Critical: critical0 host=reef\n", l, t)
+	flushWithExpected("", l, t)
+
+	l.SetFlushTransform(func(e Entry) Entry { panic("nemo") })
+	l.Print(Minor, "minor1")
+	flushWithExpected("Minor: minor1\n", l, t)
+}
+
+func testFlushString(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	expected := "Critical: critical0\nMinor: minor0\n"
+	if s := fmt.Sprint(l); s != expected {
+		t.Logf("%q != %q\n", s, expected)
+		t.Fail()
+	}
+	flushWithExpected(expected, l, t)
+
+	fb, err := NewFileBuffer(filepath.Join(t.TempDir(), "plog"), Minor, nil)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	defer fb.Close()
+	for _, b := range []Buffer{NewRingBuffer(Minor, 3), NewSliceBuffer(Minor, 0), fb} {
+		l := NewLogger(b)
+		l.SetFormat("{time} {priority}: {msg}")
+		l.Print(Minor, "minor0")
+		l.Print(Critical, "critical0")
+		l.Print(Minor, "minor1")
+
+		s := l.String()
+		if strings.Count(s, "\n") != 3 || strings.Contains(s, time.Time{}.Format(time.RFC3339)) {
+			t.Logf("unexpected buffered entries %q from %T\n", s, b)
+			t.Fail()
+		}
+		flushWithExpected(s, l, t)
+	}
+}
+
+func testFlushDefaultFormat(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.Print(Minor, "minor")
+	l.Println(Critical, "critical")
+
+	flushWithExpected("critical\nminor\n", l, t)
+}
+
+func testFlushTemplate(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("[{priority}] {msg}")
+	l.Print(Minor, "minor")
+	l.Println(Critical, "critical")
+
+	flushWithExpected("[Critical] critical\n[Minor] minor\n", l, t)
+}
+
+func testFlushTime(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{time} {msg}")
+	l.Print(Minor, "minor")
+
+	var out bytes.Buffer
+	l.FlushTo(&out)
+	ts := strings.TrimSuffix(out.String(), " minor\n")
+	if _, err := time.Parse(time.RFC3339, ts); err != nil {
+		t.Logf("unexpected time %q: %v\n", ts, err)
+		t.Fail()
+	}
+}
+
+func testFlushOutputTee(t *testing.T) {
+	var out bytes.Buffer
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.SetOutput(&out)
+	l.Println(Critical, "critical")
+
+	if out.String() != "Critical: critical\n" {
+		t.Logf("unexpected output %q\n", out.String())
+		t.Fail()
+	}
+	flushWithExpected("Critical: critical\n", l, t)
+}
+
+func testFlushOutputOnly(t *testing.T) {
+	var out bytes.Buffer
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetOutput(&out)
+	l.SetOutputMode(OutputOnly)
+	l.Print(Critical, "critical")
+
+	if out.String() != "Critical: critical\n" {
+		t.Logf("unexpected output %q\n", out.String())
+		t.Fail()
+	}
+	flushWithExpected("", l, t)
+}
+
+// testFlushThreshold asserts that entries at or above the threshold are flushed as
+// soon as they're printed, while lower priority entries stay buffered
+func testFlushThreshold(t *testing.T) {
+	var alerts bytes.Buffer
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFlushThreshold(Major, &alerts)
+
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+	l.Print(Major, "major0")
+	l.Print(Trivial, "trivial0")
+
+	if alerts.String() != "critical0\nmajor0\n" {
+		t.Logf("unexpected threshold output %q\n", alerts.String())
+		t.Fail()
+	}
+	flushWithExpected("minor0\ntrivial0\n", l, t)
+
+	// an entry promoted by age doesn't hold up the threshold flush
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetPromotionAge(time.Hour)
+	if _, err := l.SwapBuffer(rb); err != nil {
+		t.Fatalf("unexpected swap error: %v\n", err)
+	}
+	l.Print(Minor, "minor1")
+	backdate(rb, Minor, 2*time.Hour)
+	alerts.Reset()
+	l.Print(Critical, "critical1")
+	if alerts.String() != "critical1\n" {
+		t.Logf("unexpected threshold output %q\n", alerts.String())
+		t.Fail()
+	}
+}
+
+// testFlushBuffered asserts that FlushBuffered writes every entry in a single write
+// and reports no bytes written when that write fails
+func testFlushBuffered(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	w := &errWriter{n: 1}
+	n, err := l.FlushBuffered(w, 64)
+	expected := "critical0\nminor0\n"
+	if err != nil || w.out.String() != expected || n != len(expected) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, w.out.String(), expected, n)
+		t.Fail()
+	}
+
+	l.Print(Minor, "minor1")
+	if n, err := l.FlushBuffered(w, 64); err == nil || n != 0 {
+		t.Logf("expected failed write with 0 bytes, got %d, %v\n", n, err)
+		t.Fail()
+	}
+}
+
+func testFlushBinarySafe(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetBinarySafe(true)
+	l.Print(Minor, "nul\x00 bell\a esc\x1b[2J tab\t bad\xff ok\ufffd\u00e9")
+	flushWithExpected("nul\\x00 bell\\a esc\\x1b[2J tab\t bad\\xff ok\ufffd\u00e9\n", l, t)
+
+	l.SetBinarySafe(false)
+	l.Print(Minor, "nul\x00")
+	flushWithExpected("nul\x00\n", l, t)
+}
+
+func testFlushCollect(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor1")
+
+	w := &errWriter{n: 2}
+	lines, n, err := l.FlushCollect(w)
+	expected := []string{"Critical: critical0\n", "Minor: minor1\n"}
+	if err == nil || fmt.Sprint(lines) != fmt.Sprint(expected) || n != len(w.out.String()) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, lines, expected, n)
+		t.Fail()
+	}
+}
+
+// errWriter is an io.Writer that accepts n writes and fails every write after
+type errWriter struct {
+	n   int
+	out bytes.Buffer
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errors.New("nemo")
+	}
+	w.n--
+	return w.out.Write(b)
+}
+
+func testFlushAndReset(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor0")
+	l.Print(Minor, "minor1")
+
+	w := &errWriter{n: 1}
+	n, err := l.FlushAndReset(w)
+	if err == nil || n != len("critical0\n") || w.out.String() != "critical0\n" {
+		t.Logf("unexpected flush result %d, %v, %q\n", n, err, w.out.String())
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+
+	// sticky entries that Pop doesn't return are kept with or without a hook
+	rb.SetStickyQuota(1)
+	rb.SetStickyPop(false)
+	for _, hook := range []func([]string){nil, func([]string) {}} {
+		l.SetDeadLetter(hook)
+		rb.PWriteSticky(Major, []byte("deployment=v1.2.3"))
+		l.Print(Minor, "minor2")
+		l.Print(Minor, "minor3")
+		if _, err := l.FlushAndReset(&errWriter{n: 1}); err == nil {
+			t.Log("expected a write error")
+			t.Fail()
+		}
+		if n := rb.Len(); n != 1 {
+			t.Logf("expected the sticky entry to stay buffered, got %d entries\n", n)
+			t.Fail()
+		}
+		rb.SetStickyPop(true)
+		popWithExpected("deployment=v1.2.3", rb, false, t)
+		rb.SetStickyPop(false)
+	}
+}
+
+// testFlushDeadLetter asserts that entries which fail to flush are passed to the dead
+// letter hook
+func testFlushDeadLetter(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	var dead []string
+	l.SetDeadLetter(func(entries []string) {
+		dead = append(dead, entries...)
+	})
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor0")
+	l.Print(Minor, "minor1")
+
+	l.FlushTo(&errWriter{})
+	if strings.Join(dead, ",") != "critical0" {
+		t.Logf("unexpected dead letters after FlushTo %q\n", dead)
+		t.Fail()
+	}
+
+	dead = nil
+	l.Print(Minor, "minor2")
+	l.FlushAndReset(&errWriter{n: 1})
+	if strings.Join(dead, ",") != "minor1,minor0" {
+		t.Logf("unexpected dead letters after FlushAndReset %q\n", dead)
+		t.Fail()
+	}
+}
+
+// testFlushDeadLetterReentrant asserts that the dead letter hook is called once the
+// Buffer is no longer in use, so it can print and swap the Logger's Buffer without
+// deadlocking, both after a failed flush and after a failed threshold flush
+func testFlushDeadLetterReentrant(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	var dead []string
+	l.SetDeadLetter(func(entries []string) {
+		dead = append(dead, entries...)
+		l.SwapBuffer(NewRingBuffer(Minor, 3))
+		l.Print(Minor, "requeued")
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Print(Critical, "critical0")
+		l.FlushTo(&errWriter{})
+		l.SetFlushThreshold(Critical, &errWriter{})
+		l.Print(Critical, "critical1")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Log("timed out waiting for the dead letter hook\n")
+		t.FailNow()
+	}
+
+	if strings.Join(dead, ",") != "critical0,critical1" {
+		t.Logf("unexpected dead letters %q\n", dead)
+		t.Fail()
+	}
+}
+
+func testFlushRouted(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.Print(Critical, "critical0")
+	l.Print(Major, "major0")
+	l.Print(Minor, "minor0")
+	l.Print(Trivial, "trivial0")
+
+	var alerts, file bytes.Buffer
+	routes := map[LogPriority]io.Writer{
+		Critical: &alerts,
+		Major:    &alerts,
+	}
+	n, err := l.FlushRouted(routes, &file)
+	if err != nil || n != alerts.Len()+file.Len() {
+		t.Logf("unexpected flush result %d, %v\n", n, err)
+		t.Fail()
+	}
+	if alerts.String() != "critical0\nmajor0\n" || file.String() != "minor0\ntrivial0\n" {
+		t.Logf("unexpected routed output %q, %q\n", alerts.String(), file.String())
+		t.Fail()
+	}
+
+	l.Print(Minor, "minor1")
+	l.Print(Minor, "minor2")
+	l.Print(Major, "major1")
+	l.FlushRouted(routes, nil)
+	if !strings.HasSuffix(alerts.String(), "major1\n") {
+		t.Logf("unexpected routed output %q\n", alerts.String())
+		t.Fail()
+	}
+	flushWithExpected("minor2\nminor1\n", l, t)
+}
+
+func testFlushDestinations(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.Print(Major, "major0")
+	if err := l.Flush(); err != ErrNoDestination {
+		t.Logf("expected %v, got %v\n", ErrNoDestination, err)
+		t.Fail()
+	}
+	flushWithExpected("major0\n", l, t)
+
+	var alerts, file bytes.Buffer
+	l.SetDestination(Critical, &alerts)
+	l.SetDefaultDestination(&file)
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor0")
+
+	if err := l.Flush(); err != nil {
+		t.Logf("unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if alerts.String() != "critical0\n" || file.String() != "minor0\n" {
+		t.Logf("unexpected routed output %q, %q\n", alerts.String(), file.String())
+		t.Fail()
+	}
+
+	l.SetDestination(Critical, nil)
+	l.Print(Critical, "critical1")
+	l.Flush()
+	if file.String() != "minor0\ncritical1\n" {
+		t.Logf("unexpected default output %q\n", file.String())
+		t.Fail()
+	}
+
+	file.Reset()
+	l.SetDestination(Trivial, &alerts)
+	l.SetDropMarkers(true)
+	l.SetFlushOrder(ChronologicalOrder)
+	for i := 0; i < 4; i++ {
+		l.Print(Trivial, fmt.Sprint("trivial", i))
+	}
+	l.Print(Minor, "minor1")
+	l.Flush()
+	if expected := "critical0\n[plog] dropped 1 Trivial entries\ntrivial1\ntrivial2\ntrivial3\n"; alerts.String() != expected {
+		t.Logf("%q != %q\n", alerts.String(), expected)
+		t.Fail()
+	}
+	if file.String() != "minor1\n" {
+		t.Logf("unexpected default output %q\n", file.String())
+		t.Fail()
+	}
+}
+
+func testFlushStats(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	if stats := l.LastFlushStats(); stats != (FlushStats{}) {
+		t.Logf("expected zero stats before flushing, got %+v\n", stats)
+		t.Fail()
+	}
+
+	before := time.Now()
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor0")
+	l.Print(Minor, "minor1")
+	l.FlushTo(&errWriter{n: 2})
+
+	stats := l.LastFlushStats()
+	if stats.Entries != 2 || stats.Bytes != len("critical0\nminor1\n") ||
+		stats.Start.Before(before) || stats.Duration < 0 {
+		t.Logf("unexpected stats %+v\n", stats)
+		t.Fail()
+	}
+}
+
+// testFlushFatal asserts that Fatal and Fatalf flush the whole buffer before exiting
+func testFlushFatal(t *testing.T) {
+	var code int
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.Print(Minor, "minor0")
+	var out bytes.Buffer
+	l.Fatal(&out, "fatal", 0)
+	if code != 1 || out.String() != "fatal0\nminor0\n" {
+		t.Logf("unexpected exit %d, output %q\n", code, out.String())
+		t.Fail()
+	}
+
+	code = 0
+	out.Reset()
+	l.Print(Major, "major0")
+	l.Fatalf(&out, "fatal%d", 1)
+	if code != 1 || out.String() != "fatal1\nmajor0\n" {
+		t.Logf("unexpected exit %d, output %q\n", code, out.String())
+		t.Fail()
+	}
+}
+
+func testFlushChronological(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority} {msg}")
+	l.SetFlushOrder(ChronologicalOrder)
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor1")
+	l.Print(Major, "major0")
+
+	flushWithExpected("Minor minor0\nCritical critical0\nMinor minor1\nMajor major0\n", l, t)
+
+	var dead []string
+	l.SetDeadLetter(func(entries []string) {
+		dead = append(dead, entries...)
+	})
+	l.Print(Minor, "minor2")
+	l.Print(Critical, "critical1")
+	l.Print(Minor, "minor3")
+	l.FlushTo(&errWriter{n: 1})
+	if strings.Join(dead, ",") != "critical1,minor3" {
+		t.Logf("unexpected dead letters %q\n", dead)
+		t.Fail()
+	}
+	flushWithExpected("", l, t)
+}
+
+func testFlushDropMarkers(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1)
+	l := NewLogger(rb)
+	l.SetDropMarkers(true)
+	for i := 0; i < 3; i++ {
+		l.Print(Trivial, fmt.Sprint("trivial", i))
+	}
+	l.Print(Critical, "critical0")
+	l.Print(Critical, "critical1")
+
+	flushWithExpected("[plog] dropped 1 Critical entries\n[plog] dropped 2 Trivial entries\n"+
+		"critical1\ntrivial2\n", l, t)
+	flushWithExpected("", l, t)
+}
+
+// flushWithExpected flushes l and asserts that the output matches expected
+func flushWithExpected(expected string, l *Logger, t *testing.T) {
+	var out bytes.Buffer
+	n, err := l.FlushTo(&out)
+	if err != nil || out.String() != expected || n != len(expected) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, out.String(), expected, n)
+		t.Fail()
+	}
+}
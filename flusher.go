@@ -0,0 +1,84 @@
+package plog
+
+import (
+	"io"
+	"time"
+)
+
+// memStatser is implemented by Buffers that can report how full they are, such as
+// RingBuffer
+type memStatser interface {
+	MemStats() (entries int, bytes int, capEntries int)
+}
+
+// StartAdaptiveFlusher starts a goroutine that flushes the Logger's Buffer to w like
+// FlushTo, at an interval that adapts to how full the Buffer is. The first flush
+// happens after minInterval. At each flush, the fraction of the Buffer's capacity in
+// use sets the next interval, from maxInterval for an empty Buffer down to minInterval
+// for a full one, so a busy Logger flushes often and an idle one rarely
+// The fill fraction requires a Buffer that reports its capacity, such as RingBuffer;
+// other Buffers are flushed every minInterval. If maxInterval is less than
+// minInterval, minInterval is used for both
+// The flusher stops after a final flush when Close is called, and isn't started if
+// the Logger is already closed. StartAdaptiveFlusher panics if minInterval isn't
+// positive
+func (l *Logger) StartAdaptiveFlusher(w io.Writer, minInterval, maxInterval time.Duration) {
+	if minInterval <= 0 {
+		panic("plog: StartAdaptiveFlusher called with a non-positive interval")
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	l.sessLock.RLock()
+	defer l.sessLock.RUnlock()
+	if l.closed.Load() {
+		return
+	}
+
+	l.flushers.Add(1)
+	go l.adaptiveFlush(w, minInterval, maxInterval)
+}
+
+// adaptiveFlush implements StartAdaptiveFlusher
+func (l *Logger) adaptiveFlush(w io.Writer, minInterval, maxInterval time.Duration) {
+	defer l.flushers.Done()
+
+	timer := time.NewTimer(minInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			l.FlushTo(w)
+			return
+		case <-timer.C:
+		}
+
+		next := flushInterval(l.fill(), minInterval, maxInterval)
+		l.FlushTo(w)
+		timer.Reset(next)
+	}
+}
+
+// fill returns the fraction of the Buffer's capacity in use, or 1 if the Buffer
+// doesn't report its capacity
+func (l *Logger) fill() float64 {
+	ms, ok := l.GetBuffer().(memStatser)
+	if !ok {
+		return 1
+	}
+
+	entries, _, capEntries := ms.MemStats()
+	if capEntries <= 0 {
+		return 0
+	}
+	return float64(entries) / float64(capEntries)
+}
+
+// flushInterval returns the interval between lo and hi for a Buffer that is fill
+// full, scaling linearly from hi when empty to lo when full
+func flushInterval(fill float64, lo, hi time.Duration) time.Duration {
+	fill = min(max(fill, 0), 1)
+	return hi - time.Duration(fill*float64(hi-lo))
+}
@@ -0,0 +1,78 @@
+package plog
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveFlusher runs subtests covering the adaptive flusher
+func TestAdaptiveFlusher(t *testing.T) {
+	t.Run("interval", testAdaptiveFlusherInterval)
+	t.Run("flush", testAdaptiveFlusherFlush)
+}
+
+func testAdaptiveFlusherInterval(t *testing.T) {
+	cases := []struct {
+		fill     float64
+		expected time.Duration
+	}{
+		{-1, time.Second},
+		{0, time.Second},
+		{0.5, 550 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if d := flushInterval(c.fill, 100*time.Millisecond, time.Second); d != c.expected {
+			t.Logf("fill %v: %v != %v\n", c.fill, d, c.expected)
+			t.Fail()
+		}
+	}
+}
+
+// lockedWriter is an io.Writer that can be read while another goroutine writes to it
+type lockedWriter struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (w *lockedWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(b)
+}
+
+func (w *lockedWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+// testAdaptiveFlusherFlush asserts that the flusher flushes buffered entries, and
+// that Close makes a final flush before stopping it
+func testAdaptiveFlusherFlush(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	w := &lockedWriter{}
+	l.Print(Minor, "minor0")
+	l.StartAdaptiveFlusher(w, time.Millisecond, time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s := w.String(); s != "minor0\n" {
+		t.Logf("unexpected output %q\n", s)
+		t.Fail()
+	}
+
+	// the Buffer was nearly empty at the first flush, so the next one is most of an
+	// hour away and only Close can flush this entry in time
+	l.Print(Minor, "minor1")
+	l.Close()
+	if s := w.String(); s != "minor0\nminor1\n" {
+		t.Logf("unexpected output after close %q\n", s)
+		t.Fail()
+	}
+}
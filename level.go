@@ -0,0 +1,64 @@
+package plog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultLevels maps common log level names to priorities. It is used by Logger.Log
+// until SetLevels is called
+var DefaultLevels = map[string]LogPriority{
+	"debug":   Trivial,
+	"info":    Minor,
+	"warn":    Major,
+	"warning": Major,
+	"error":   Critical,
+	"fatal":   Critical,
+}
+
+// SetLevels sets the level names recognized by Log and the priorities they map to
+// Level names are matched case-insensitively. Passing nil restores DefaultLevels
+func (l *Logger) SetLevels(levels map[string]LogPriority) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	if levels == nil {
+		l.levels = nil
+		return
+	}
+
+	l.levels = make(map[string]LogPriority, len(levels))
+	for name, p := range levels {
+		l.levels[strings.ToLower(name)] = p
+	}
+}
+
+// SetUnknownLevelWarning sets whether Log prints a warning at Major priority when it's
+// called with a level name that isn't recognized
+func (l *Logger) SetUnknownLevelWarning(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.warnLevel = enabled
+}
+
+// Log prints msg with the priority that level maps to. Unrecognized levels use the
+// Buffer's default priority
+func (l *Logger) Log(level string, msg string) {
+	l.confLock.RLock()
+	levels, warn := l.levels, l.warnLevel
+	l.confLock.RUnlock()
+
+	if levels == nil {
+		levels = DefaultLevels
+	}
+
+	p, ok := levels[strings.ToLower(level)]
+	if !ok {
+		if warn {
+			l.Print(Major, fmt.Sprintf("plog: unknown log level %q", level))
+		}
+		p = l.GetBuffer().GetPriority()
+	}
+	l.Print(p, msg)
+}
@@ -0,0 +1,47 @@
+package plog
+
+import (
+	"testing"
+)
+
+// TestLog runs subtests covering level name mapping in Logger.Log
+func TestLog(t *testing.T) {
+	t.Run("default levels", testLogDefaultLevels)
+	t.Run("custom levels", testLogCustomLevels)
+	t.Run("unknown level", testLogUnknownLevel)
+}
+
+func testLogDefaultLevels(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Log("debug", "debug0")
+	l.Log("ERROR", "error0")
+	l.Log("warn", "warn0")
+
+	popWithExpected("Critical error0", rb, true, t)
+	popWithExpected("Major warn0", rb, true, t)
+	popWithExpected("Trivial debug0", rb, true, t)
+}
+
+func testLogCustomLevels(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetLevels(map[string]LogPriority{"P0": Critical, "P3": Trivial})
+	l.Log("p3", "p3")
+	l.Log("p0", "p0")
+	l.Log("error", "error0")
+
+	popWithExpected("Critical p0", rb, true, t)
+	popWithExpected("Minor error0", rb, true, t)
+	popWithExpected("Trivial p3", rb, true, t)
+}
+
+func testLogUnknownLevel(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetUnknownLevelWarning(true)
+	l.Log("verbose", "verbose0")
+
+	popWithExpected(`Major plog: unknown log level "verbose"`, rb, true, t)
+	popWithExpected("Minor verbose0", rb, true, t)
+}
@@ -0,0 +1,245 @@
+package plog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// marshalMagic prefixes every encoded RingBuffer
+const marshalMagic = "plog"
+
+// marshalVersion is the version of the encoding written by MarshalBinary
+// It must be incremented whenever the encoding changes, and UnmarshalBinary must list
+// each version it reads
+const marshalVersion byte = 3
+
+// errTruncated is returned when an encoded RingBuffer ends unexpectedly
+var errTruncated = errors.New("plog: truncated RingBuffer encoding")
+
+// MarshalBinary encodes the RingBuffer's default priority, capacity, sequence number,
+// and entries, including sticky entries and their fields, implementing
+// encoding.BinaryMarshaler
+// The encoding starts with a version byte so that UnmarshalBinary can reject blobs
+// written by an incompatible version of this package. Other settings, such as the
+// overflow policy, are not encoded
+func (r *RingBuffer) MarshalBinary() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	buf := []byte(marshalMagic)
+	buf = append(buf, marshalVersion)
+	buf = binary.AppendVarint(buf, int64(r.p))
+	buf = binary.AppendUvarint(buf, uint64(r.bufCap))
+	buf = binary.AppendUvarint(buf, r.seq)
+
+	buf = binary.AppendUvarint(buf, uint64(r.total))
+	for _, i := range r.prios {
+		pr := r.getRing(i)
+
+		// walk from the next write position so entries are encoded oldest first
+		rg := pr.r
+		for j := 0; j < rg.Len(); j++ {
+			if e, ok := rg.Value.(*entry); ok {
+				buf = appendEntry(buf, e)
+			}
+			rg = rg.Next()
+		}
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(r.sticky)))
+	for _, e := range r.sticky {
+		buf = appendEntry(buf, e)
+	}
+
+	return buf, nil
+}
+
+// appendEntry appends the encoding of e to buf
+func appendEntry(buf []byte, e *entry) []byte {
+	var t int64
+	if !e.t.IsZero() {
+		t = e.t.UnixNano()
+	}
+
+	buf = binary.AppendVarint(buf, int64(e.p))
+	buf = binary.AppendVarint(buf, t)
+	buf = binary.AppendUvarint(buf, e.seq)
+	buf = appendBytes(buf, e.data)
+
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendBytes(buf, []byte(k))
+		buf = appendBytes(buf, []byte(e.fields[k]))
+	}
+	return buf
+}
+
+// appendBytes appends the length of b followed by b to buf
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// UnmarshalBinary replaces the RingBuffer's default priority, capacity, and entries
+// with those encoded by MarshalBinary, implementing encoding.BinaryUnmarshaler
+// Sequence numbering resumes after the highest sequence number that was encoded
+// Other settings are left unchanged. Data written by the previous version of the
+// encoding, which didn't include fields, is read with no fields. If data was written
+// by an unsupported version of the encoding, or is malformed, UnmarshalBinary returns
+// an error and leaves the RingBuffer unchanged. If restoring an entry fails, such as
+// when the overflow policy rejects it or the spill writer fails, the rest are still
+// restored and the first error is returned
+func (r *RingBuffer) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(marshalMagic)) || len(data) <= len(marshalMagic) {
+		return errors.New("plog: data is not a RingBuffer encoding")
+	}
+	d := decoder{buf: data[len(marshalMagic)+1:]}
+	switch v := data[len(marshalMagic)]; v {
+	case 2:
+		// entries have no fields
+	case 3:
+		d.fields = true
+	default:
+		return fmt.Errorf("plog: unsupported RingBuffer encoding version %d, this package reads versions 2 and 3", v)
+	}
+
+	p := LogPriority(d.varint())
+	bufCap := int(d.uvarint())
+	seq := d.uvarint()
+	entries := d.entries()
+	sticky := d.entries()
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("plog: %d unexpected trailing bytes in RingBuffer encoding", len(d.buf))
+	}
+	if bufCap <= 0 {
+		return fmt.Errorf("plog: invalid RingBuffer capacity %d", bufCap)
+	}
+	counts := make(map[LogPriority]int)
+	for _, e := range entries {
+		if counts[e.p]++; counts[e.p] > bufCap {
+			return fmt.Errorf("plog: more than %d %s entries in RingBuffer encoding of capacity %d", bufCap, PriorityString(e.p), bufCap)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.p = p
+	r.bufCap = bufCap
+	r.buf = nil
+	r.sparse = make(map[int]*prioRing)
+	r.prios = nil
+	r.total = 0
+	r.size = 0
+	r.highP = 0
+	r.line = nil
+	r.seq = seq
+	r.sticky = sticky
+	for _, e := range sticky {
+		r.size += len(e.data)
+		r.seq = max(r.seq, e.seq)
+	}
+	var firstErr error
+	for _, e := range entries {
+		if err := r.insert(e); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("plog: restoring RingBuffer entry: %w", err)
+		}
+		r.seq = max(r.seq, e.seq)
+	}
+
+	return firstErr
+}
+
+// decoder reads the values written by MarshalBinary, recording the first error
+type decoder struct {
+	buf    []byte
+	fields bool // whether entries are followed by their fields
+	err    error
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// entries reads a count followed by that many entries
+func (d *decoder) entries() []*entry {
+	n := d.uvarint()
+	var entries []*entry
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		e := &entry{
+			p: LogPriority(d.varint()),
+		}
+		if t := d.varint(); t != 0 {
+			e.t = time.Unix(0, t)
+		}
+		e.seq = d.uvarint()
+
+		e.data = d.bytes()
+
+		if d.fields {
+			for n := d.uvarint(); n > 0 && d.err == nil; n-- {
+				if e.fields == nil {
+					e.fields = make(map[string]string)
+				}
+				k := string(d.bytes())
+				e.fields[k] = string(d.bytes())
+			}
+		}
+		if d.err != nil {
+			return nil
+		}
+
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// bytes reads a length followed by that many bytes, returning a copy of them
+func (d *decoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err == nil && uint64(len(d.buf)) < size {
+		d.err = errTruncated
+	}
+	if d.err != nil {
+		return nil
+	}
+	b := append([]byte(nil), d.buf[:size]...)
+	d.buf = d.buf[size:]
+	return b
+}
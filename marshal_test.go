@@ -0,0 +1,164 @@
+package plog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestMarshal runs subtests covering RingBuffer binary encoding
+func TestMarshal(t *testing.T) {
+	t.Run("round trip", testMarshalRoundTrip)
+	t.Run("version", testMarshalVersion)
+	t.Run("truncated", testMarshalTruncated)
+	t.Run("seq", testMarshalSeq)
+	t.Run("fields", testMarshalFields)
+}
+
+// testMarshalFields asserts that entry fields survive a round trip and that blobs
+// from the version before fields were encoded are still read
+func testMarshalFields(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.PrintErrChain(Major, fmt.Errorf("flush: %w", errTruncated))
+	rb.Write([]byte("minor0"))
+	data, _ := rb.MarshalBinary()
+
+	restored := NewRingBuffer(Minor, 3)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Logf("unexpected unmarshal error: %v\n", err)
+		t.FailNow()
+	}
+	e, err := restored.PopEntry()
+	if err != nil || len(e.Fields) != 2 || e.Fields["err.1"] != errTruncated.Error() {
+		t.Logf("unexpected entry %+v, %v\n", e, err)
+		t.Fail()
+	}
+	if e, err := restored.PopEntry(); err != nil || e.Message != "minor0" || e.Fields != nil {
+		t.Logf("unexpected entry %+v, %v\n", e, err)
+		t.Fail()
+	}
+
+	old := append([]byte(marshalMagic), 2)
+	old = binary.AppendVarint(old, int64(Minor))
+	old = binary.AppendUvarint(old, 3)
+	old = binary.AppendUvarint(old, 1)
+	old = binary.AppendUvarint(old, 1)
+	old = binary.AppendVarint(old, int64(Major))
+	old = binary.AppendVarint(old, 0)
+	old = binary.AppendUvarint(old, 1)
+	old = binary.AppendUvarint(old, uint64(len("major0")))
+	old = append(old, "major0"...)
+	old = binary.AppendUvarint(old, 0)
+	if err := restored.UnmarshalBinary(old); err != nil {
+		t.Logf("unexpected unmarshal error: %v\n", err)
+		t.FailNow()
+	}
+	popWithExpected("major0", restored, false, t)
+}
+
+// testMarshalSeq asserts that sequence numbers survive a round trip and that numbering
+// resumes after the highest encoded sequence number
+func testMarshalSeq(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor1"))
+	data, _ := rb.MarshalBinary()
+
+	restored := NewRingBuffer(Minor, 3)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Logf("unexpected unmarshal error: %v\n", err)
+		t.FailNow()
+	}
+	restored.Write([]byte("minor2"))
+	for _, seq := range []uint64{3, 2, 1} {
+		if e, err := restored.PopEntry(); err != nil || e.Seq != seq {
+			t.Logf("expected seq %d, got %d, %v\n", seq, e.Seq, err)
+			t.Fail()
+		}
+	}
+}
+
+func testMarshalRoundTrip(t *testing.T) {
+	rb := NewRingBuffer(Major, 3)
+	rb.Write([]byte("major0"))
+	rb.PWrite(Minor, []byte("minor0"))
+	rb.Write([]byte("major1"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWriteSticky(Trivial, []byte("deployment=v1.2.3"))
+
+	data, err := rb.MarshalBinary()
+	if err != nil {
+		t.Logf("unexpected marshal error: %v\n", err)
+		t.FailNow()
+	}
+
+	restored := NewRingBuffer(Trivial, 1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Logf("unexpected unmarshal error: %v\n", err)
+		t.FailNow()
+	}
+	if p := restored.GetPriority(); p != Major {
+		t.Logf("expected %v, got %v\n", Major, p)
+		t.Fail()
+	}
+	popWithExpected("critical0", restored, false, t)
+	popWithExpected("major1", restored, false, t)
+	popWithExpected("major0", restored, false, t)
+	popWithExpected("minor0", restored, false, t)
+	popWithExpected("deployment=v1.2.3", restored, false, t)
+}
+
+// testMarshalVersion feeds a blob with a bumped version and expects a descriptive
+// error that leaves the RingBuffer unchanged
+func testMarshalVersion(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	data, _ := rb.MarshalBinary()
+	data[len(marshalMagic)] = marshalVersion + 1
+
+	restored := NewRingBuffer(Minor, 3)
+	restored.Write([]byte("minor1"))
+	err := restored.UnmarshalBinary(data)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprint("unsupported RingBuffer encoding version ", marshalVersion+1)) {
+		t.Logf("expected unsupported version error, got %v\n", err)
+		t.Fail()
+	}
+	popWithExpected("minor1", restored, false, t)
+}
+
+func testMarshalTruncated(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	data, _ := rb.MarshalBinary()
+
+	if err := NewRingBuffer(Minor, 3).UnmarshalBinary(data[:len(data)-1]); err != errTruncated {
+		t.Logf("expected %v, got %v\n", errTruncated, err)
+		t.Fail()
+	}
+	if err := NewRingBuffer(Minor, 3).UnmarshalBinary([]byte("nemo")); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+
+	// a capacity of 1 can't hold the two entries that follow it
+	rb.Write([]byte("minor1"))
+	data, _ = rb.MarshalBinary()
+	data[len(marshalMagic)+2] = 1
+	if err := NewRingBuffer(Minor, 3).UnmarshalBinary(data); err == nil || !strings.Contains(err.Error(), "more than 1 Minor entries") {
+		t.Logf("expected too many entries error, got %v\n", err)
+		t.Fail()
+	}
+
+	data, _ = rb.MarshalBinary()
+	restored := NewRingBuffer(Minor, 3)
+	restored.SetOverflowPolicy(RejectNewest)
+	restored.SetMaxEntries(1)
+	if err := restored.UnmarshalBinary(data); !errors.Is(err, ErrBufferFull) {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+	popWithExpected("minor0", restored, false, t)
+}
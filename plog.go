@@ -3,10 +3,42 @@ package plog
 import (
 	"bytes"
 	"container/ring"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrBufferEmpty is returned when popping from a Buffer that holds no entries
+var ErrBufferEmpty = errors.New("Buffer is empty")
+
+// ErrBufferFull is returned when a Buffer rejects a write because it is full
+var ErrBufferFull = errors.New("Buffer is full")
+
+// ErrSessionDone is returned when an append session is used after it has been
+// committed or aborted
+var ErrSessionDone = errors.New("append session is already done")
+
+// ErrTimeout is returned when waiting for an entry takes longer than the timeout
+var ErrTimeout = errors.New("timed out waiting for entry")
+
+// ErrLoggerClosed is returned when printing or committing an append session after
+// the Logger has been closed
+var ErrLoggerClosed = errors.New("Logger is closed")
+
+// ErrNoDestination is returned by Flush when no destination is set, so there's
+// nowhere to write entries
+var ErrNoDestination = errors.New("Logger has no flush destination")
+
+// DefaultCloseTimeout is how long Close waits for open append sessions by default
+const DefaultCloseTimeout = 5 * time.Second
+
 // LogPriority is a simple enum for determining the order in which Logger releases
 // logs from the buffer
 type LogPriority int
@@ -18,7 +50,17 @@ const (
 	Critical
 )
 
+// PriorityString returns the name of p, which is the alias registered for it by
+// RegisterPriorityAlias, if any, or its canonical name
 func PriorityString(p LogPriority) string {
+	if name, ok := aliasName(p); ok {
+		return name
+	}
+	return priorityName(p)
+}
+
+// priorityName returns the canonical name of p
+func priorityName(p LogPriority) string {
 	switch p {
 	case Trivial:
 		return "Trivial"
@@ -35,17 +77,103 @@ func PriorityString(p LogPriority) string {
 
 // Logger stores logs in buffer interface and enables writing to that buffer
 type Logger struct {
-	buf  Buffer
-	aBuf *bytes.Buffer
-	lock *sync.Mutex
+	bufLock *sync.RWMutex // guards buf, held for reading while the Buffer is in use
+	buf     Buffer
+	aBuf    *bytes.Buffer
+	lock    *sync.Mutex
+
+	confLock  *sync.RWMutex // guards the fields below
+	format    string
+	out       io.Writer
+	outMode   OutputMode
+	logPanics bool
+
+	flushP LogPriority // minimum priority flushed on write
+	flushW io.Writer   // destination for flushes on write
+
+	dests   map[LogPriority]io.Writer // destinations used by Flush, by priority
+	destDef io.Writer                 // destination used by Flush for other priorities
+
+	levels    map[string]LogPriority // level names used by Log, or nil for the defaults
+	warnLevel bool                   // whether Log warns about unknown levels
+
+	deadFn func(entries []string) // receives entries that failed to flush
+	paused bool                   // whether printed entries are dropped
+
+	lastFlush  FlushStats // statistics for the most recent flush
+	flushOrder FlushOrder // order in which flushes write entries
+	dropMarks  bool       // whether flushes report entries dropped by overflow
+	normalize  bool       // whether printed line endings are rewritten to "\n"
+	writeEmpty bool       // whether AppendDone writes an empty append buffer
+	binarySafe bool       // whether text output escapes non-printable bytes
+	strictLock bool       // whether Append and AppendDone panic without the lock held
+
+	transform func(Entry) Entry // applied to entries as they're flushed, or nil
+
+	sampleP LogPriority // priority below which entries are sampled
+	sampleN int         // keep 1 in sampleN entries below sampleP, or 0 to keep all
+
+	ctxKeys map[interface{}]string // context keys attached by PrintCtx, by field name
+
+	prioFormats map[LogPriority]func(string) string // write time decoration by priority
+
+	dropped atomic.Int64 // number of entries dropped while paused or by sampling
+	sampled atomic.Int64 // number of entries seen below the sampling threshold
+
+	deadLock  *sync.Mutex // guards deadQueue
+	deadQueue []string    // entries for the dead letter hook, held until bufLock is released
+
+	drainLock   *sync.Mutex                    // guards the fields below
+	drainNotify chan struct{}                  // closed when the Logger stores entries
+	drains      map[<-chan Entry]chan struct{} // stop channels of DrainMin subscriptions
+
+	countLock *sync.Mutex       // guards counts, held while a count is printed
+	counts    map[string]uint64 // counters incremented by Count
+
+	sessLock     *sync.RWMutex   // guards the fields below, held for reading while committing
+	sessions     *sync.WaitGroup // counts open append sessions
+	closing      bool            // whether new append sessions are rejected
+	closed       atomic.Bool     // whether writes are rejected, only set with sessLock held
+	closeTimeout time.Duration   // how long Close waits for open append sessions
+
+	done     chan struct{}   // closed by Close to stop background flushers
+	flushers *sync.WaitGroup // counts running background flushers and DrainMin subscriptions
 }
 
+// OutputMode determines how a Logger with an output writer handles entries
+type OutputMode int
+
+const (
+	// OutputTee writes each entry to the output writer and to the Buffer
+	OutputTee OutputMode = iota
+	// OutputOnly writes each entry to the output writer without buffering it
+	OutputOnly
+)
+
 // NewLogger returns a reference to a newly allocated Logger struct
+// NewLogger panics if b is nil
 func NewLogger(b Buffer) *Logger {
+	if b == nil {
+		panic("plog: NewLogger called with a nil Buffer")
+	}
+
 	return &Logger{
-		buf:  b,
-		aBuf: bytes.NewBuffer([]byte{}),
-		lock: &sync.Mutex{},
+		bufLock: &sync.RWMutex{},
+		buf:     b,
+		aBuf:    bytes.NewBuffer([]byte{}),
+		lock:    &sync.Mutex{},
+
+		confLock:  &sync.RWMutex{},
+		deadLock:  &sync.Mutex{},
+		drainLock: &sync.Mutex{},
+		countLock: &sync.Mutex{},
+
+		sessLock:     &sync.RWMutex{},
+		sessions:     &sync.WaitGroup{},
+		closeTimeout: DefaultCloseTimeout,
+
+		done:     make(chan struct{}),
+		flushers: &sync.WaitGroup{},
 	}
 }
 
@@ -62,26 +190,234 @@ func (l *Logger) Unlock() {
 // Append appends a string to Logger's append buffer
 // It's a good idea to call l.Lock() before entering this function
 func (l *Logger) Append(s string) {
+	l.checkLocked("Append")
 	l.aBuf.WriteString(s)
 }
 
+// SetStrictLocking sets whether Append and AppendDone panic when the Logger's lock
+// isn't held. It's meant for tests and debugging, as it only detects that no
+// goroutine holds the lock, not that the caller does. It's disabled by default
+func (l *Logger) SetStrictLocking(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.strictLock = enabled
+}
+
+// checkLocked panics if strict locking is enabled and the Logger's lock is free
+func (l *Logger) checkLocked(fn string) {
+	l.confLock.RLock()
+	strict := l.strictLock
+	l.confLock.RUnlock()
+
+	if strict && l.lock.TryLock() {
+		l.lock.Unlock()
+		panic("plog: Logger." + fn + " called without holding the Logger's lock")
+	}
+}
+
 // AppendDone signals that the caller is done appending to the current ring buffer
 // value and that the ring buffer reference should be updated.
 // The Logger's Lock() function should be called prior to using this function
+// If nothing was appended, AppendDone writes nothing unless SetEmptyAppends has
+// enabled empty entries
 func (l *Logger) AppendDone(p LogPriority) {
-	l.buf.PWrite(p, l.aBuf.Bytes())
+	l.checkLocked("AppendDone")
+
+	l.confLock.RLock()
+	writeEmpty := l.writeEmpty
+	l.confLock.RUnlock()
+
+	if l.aBuf.Len() > 0 || writeEmpty {
+		l.write(p, l.aBuf.Bytes())
+	}
 	l.aBuf.Reset()
 }
 
+// SetEmptyAppends sets whether AppendDone writes an empty entry when nothing was
+// appended since the previous call. It's disabled by default, so a stray AppendDone
+// doesn't use up a slot in the Buffer
+func (l *Logger) SetEmptyAppends(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.writeEmpty = enabled
+}
+
+// AppendToken identifies an append session started by Logger.BeginAppend
+// Each session owns its own scratch buffer, so separate goroutines can assemble
+// entries in parallel without holding the Logger's lock. A single token should not
+// be shared between goroutines
+type AppendToken struct {
+	buf    *bytes.Buffer
+	closed bool // whether the session was started after the Logger was closed
+}
+
+// appendPool holds scratch buffers for append sessions
+var appendPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledAppend is the largest append buffer capacity returned to appendPool, so a
+// single large entry doesn't pin its buffer's memory
+const maxPooledAppend = 64 << 10
+
+// BeginAppend starts a new append session and returns its token
+// Session buffers are pooled and reused once the session ends, so the Buffer must not
+// retain the slices passed to PWrite, which rules out RingBuffers created with
+// WithZeroCopy. Sessions started after Close has been called hold nothing and fail to
+// commit with ErrLoggerClosed
+func (l *Logger) BeginAppend() *AppendToken {
+	l.sessLock.RLock()
+	defer l.sessLock.RUnlock()
+
+	if l.closing {
+		return &AppendToken{
+			closed: true,
+		}
+	}
+	l.sessions.Add(1)
+
+	buf := appendPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return &AppendToken{
+		buf: buf,
+	}
+}
+
+// endAppend ends the session identified by tok and returns its buffer to the pool
+func (l *Logger) endAppend(tok *AppendToken) {
+	if tok.buf.Cap() <= maxPooledAppend {
+		appendPool.Put(tok.buf)
+	}
+	tok.buf = nil
+	l.sessions.Done()
+}
+
+// AppendTo appends a string to the session identified by tok
+// Appending to a session that is already done has no effect
+func (l *Logger) AppendTo(tok *AppendToken, s string) {
+	if tok == nil || tok.buf == nil {
+		return
+	}
+	tok.buf.WriteString(s)
+}
+
+// CommitAppend writes the contents of the session identified by tok into the Buffer
+// with priority p and ends the session
+// Once Close has given up waiting for open sessions, CommitAppend ends the session
+// without writing it and returns ErrLoggerClosed
+func (l *Logger) CommitAppend(tok *AppendToken, p LogPriority) error {
+	if tok == nil {
+		return ErrSessionDone
+	}
+	if tok.closed {
+		return ErrLoggerClosed
+	}
+	if tok.buf == nil {
+		return ErrSessionDone
+	}
+
+	l.sessLock.RLock()
+	if l.closed.Load() {
+		l.sessLock.RUnlock()
+		l.endAppend(tok)
+		return ErrLoggerClosed
+	}
+	err := l.write(p, tok.buf.Bytes())
+	l.sessLock.RUnlock()
+
+	l.endAppend(tok)
+	return err
+}
+
+// AbortAppend discards the contents of the session identified by tok and ends the
+// session without writing anything to the Buffer
+func (l *Logger) AbortAppend(tok *AppendToken) {
+	if tok == nil || tok.buf == nil {
+		return
+	}
+	l.endAppend(tok)
+}
+
+// SetCloseTimeout sets how long Close waits for open append sessions to end
+// A timeout of zero or less makes Close reject commits without waiting
+func (l *Logger) SetCloseTimeout(d time.Duration) {
+	l.sessLock.Lock()
+	defer l.sessLock.Unlock()
+
+	l.closeTimeout = d
+}
+
+// Close stops the Logger from starting new append sessions and waits for the open
+// ones to be committed or aborted, up to the timeout set by SetCloseTimeout
+// Commits that finish before Close returns are written as usual, and every commit
+// after that fails with ErrLoggerClosed. Close returns an error if sessions were
+// still open when the timeout expired. Background flushers started with
+// StartAdaptiveFlusher make a final flush and stop before Close returns, and DrainMin
+// subscriptions end, closing their channels, before it returns as well
+// Once closed, the Logger drops printed entries, counting them in Dropped, and the
+// print methods that return an error return ErrLoggerClosed. Entries that are already
+// buffered can still be flushed
+func (l *Logger) Close() error {
+	l.sessLock.Lock()
+	if l.closed.Load() {
+		l.sessLock.Unlock()
+		return nil
+	}
+	l.closing = true
+	timeout := l.closeTimeout
+	l.sessLock.Unlock()
+
+	// no sessions can be opened once closing is set, so the wait group only
+	// counts down from here
+	idle := make(chan struct{})
+	go func() {
+		l.sessions.Wait()
+		close(idle)
+	}()
+
+	var err error
+	timer := time.NewTimer(max(timeout, 0))
+	select {
+	case <-idle:
+	case <-timer.C:
+		select {
+		case <-idle:
+		default:
+			err = fmt.Errorf("plog: append sessions still open after %v", timeout)
+		}
+	}
+	timer.Stop()
+
+	// taking the lock waits for commits that are still writing
+	l.sessLock.Lock()
+	first := !l.closed.Swap(true)
+	l.sessLock.Unlock()
+
+	if first {
+		close(l.done)
+	}
+	l.flushers.Wait()
+	return err
+}
+
+// Closed returns whether Close has finished closing the Logger
+func (l *Logger) Closed() bool {
+	return l.closed.Load()
+}
+
 // Print inserts s into the p priority ring buffer and updates the Logger's reference
 // to the ring buffer
 func (l *Logger) Print(p LogPriority, s string) {
-	l.buf.PWrite(p, []byte(s))
+	l.write(p, []byte(s))
 }
 
 // PrintDef operates the same way as Logger.Print, but uses the Buffer's set Priority
 func (l *Logger) PrintDef(s string) {
-	l.Print(l.buf.GetPriority(), s)
+	l.Print(l.GetBuffer().GetPriority(), s)
 }
 
 // Println addends a newline to s and calls l.Print
@@ -92,112 +428,2506 @@ func (l *Logger) Println(p LogPriority, s string) {
 }
 
 // Printf applies formatting to format before passing it to l.Print
+// If formatting panics, a placeholder naming the panic is printed instead
 func (l *Logger) Printf(p LogPriority, format string, v ...interface{}) {
-	s := fmt.Sprintf(format, v...)
+	s := safeFormat(func() string { return fmt.Sprintf(format, v...) })
 	l.Print(p, s)
 }
 
-// GetBuffer returns the reference to the Logger's internal Buffer
-func (l *Logger) GetBuffer() Buffer {
-	return l.buf
+// PrintlnDef operates the same way as Logger.Println, but uses the Buffer's set Priority
+func (l *Logger) PrintlnDef(s string) {
+	l.Println(l.GetBuffer().GetPriority(), s)
 }
 
-// Buffer allows you to define custom write and output behavior while still implementing
-// the io.Writer interface for use with other packages
-type Buffer interface {
-	Pop(bool) (string, error)
-	Write([]byte) (int, error)
-	PWrite(LogPriority, []byte) (int, error)
-	GetPriority() LogPriority
-	SetPriority(LogPriority)
+// PrintfDef operates the same way as Logger.Printf, but uses the Buffer's set Priority
+func (l *Logger) PrintfDef(format string, v ...interface{}) {
+	l.Printf(l.GetBuffer().GetPriority(), format, v...)
 }
 
-// RingBuffer uses a ring buffer to store logs and manage memory usage
-// This buffer optimizes for write performance over read performance
-type RingBuffer struct {
-	p      LogPriority
-	bufCap int
-	buf    map[int]*ring.Ring
-	lock   *sync.Mutex
-	highP  int // current highest priority value
+// Write implements io.Writer, printing a copy of b at the Buffer's set Priority as
+// PrintDef would, so the Logger's pause, sampling, formatting, and output settings
+// apply. It returns 0 and an error if b wasn't stored because the Logger is closed or
+// the Buffer is full. Entries dropped by pausing or sampling count as written
+func (l *Logger) Write(b []byte) (int, error) {
+	err := l.write(l.GetBuffer().GetPriority(), append([]byte(nil), b...))
+	if err == ErrLoggerClosed {
+		return 0, err
+	}
+	return writeResult(b, err)
 }
 
-// NewRingBuffer initializes a new RingBuffer struct with the given LogPriority and
-// buffer size and returns a reference to it
-func NewRingBuffer(p LogPriority, size int) *RingBuffer {
-	return &RingBuffer{
-		p:      p,
-		bufCap: size,
-		buf:    make(map[int]*ring.Ring),
-		lock:   &sync.Mutex{},
-		highP:  0,
+// Count increments the counter called name, prints "<name> count: <n>" with priority
+// p, and returns the new count n. Counters start at 0 and are kept for the lifetime of
+// the Logger. Concurrent calls with the same name each get a distinct count, and
+// their entries are printed in the order of their counts
+func (l *Logger) Count(p LogPriority, name string) uint64 {
+	l.countLock.Lock()
+	defer l.countLock.Unlock()
+
+	if l.counts == nil {
+		l.counts = make(map[string]uint64)
 	}
+	l.counts[name]++
+	n := l.counts[name]
+	l.Print(p, fmt.Sprintf("%s count: %d", name, n))
+	return n
 }
 
-// GetPriority returns the RingBuffer's LogPriority
-func (r *RingBuffer) GetPriority() LogPriority {
-	return r.p
+// exit terminates the program after a Fatal call. Tests replace it to observe exits
+var exit = os.Exit
+
+// Fatal prints the operands, formatted as by fmt.Sprint, at Critical priority, flushes
+// the entire Buffer to w, and calls os.Exit(1). Flushing everything rather than only
+// the fatal entry preserves the buffered context leading up to the exit
+func (l *Logger) Fatal(w io.Writer, v ...interface{}) {
+	l.Print(Critical, safeFormat(func() string { return fmt.Sprint(v...) }))
+	l.FlushTo(w)
+	exit(1)
 }
 
-// SetPriority sets the RingBuffer's default priority
-func (r *RingBuffer) SetPriority(p LogPriority) {
-	r.p = p
+// Fatalf is equivalent to Fatal, but formats its operands as by fmt.Sprintf
+func (l *Logger) Fatalf(w io.Writer, format string, v ...interface{}) {
+	l.Print(Critical, safeFormat(func() string { return fmt.Sprintf(format, v...) }))
+	l.FlushTo(w)
+	exit(1)
 }
 
-// Pop returns the RingBuffer's contents prioritizing higher priority and newer
-// logs first
-func (r *RingBuffer) Pop(priPrefix bool) (string, error) {
-	_, ok := r.buf[r.highP]
-	if !ok {
-		return "", fmt.Errorf("Buffer is empty")
+// SetOutput sets a writer that receives each entry as soon as it is printed, formatted
+// according to the Logger's format, or as "{priority}: {msg}", such as "Critical:
+// disk full", if the Logger has no format. Whether the entry is also stored in the
+// Buffer is controlled by SetOutputMode; by default it is, even if the write to w
+// fails. Entries written directly to the Buffer, rather than through the Logger, are
+// not written to w
+// Passing nil disables eager output and resumes buffering every entry
+func (l *Logger) SetOutput(w io.Writer) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.out = w
+}
+
+// SetOutputMode sets whether entries written to the output writer are also buffered
+// The mode has no effect until an output writer is set
+func (l *Logger) SetOutputMode(m OutputMode) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.outMode = m
+}
+
+// Pause makes the Logger drop every entry printed until Resume is called, without
+// writing it to the output writer or the Buffer. Dropped entries are counted by
+// Dropped. Pausing is a toggle rather than a counter, so a single call to Resume
+// resumes logging no matter how many times Pause was called
+// Entries written directly to the Buffer, rather than through the Logger, are not
+// affected
+func (l *Logger) Pause() {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.paused = true
+}
+
+// Resume resumes logging after a call to Pause
+func (l *Logger) Resume() {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.paused = false
+}
+
+// Dropped returns the number of entries dropped because the Logger was paused or
+// closed, or because they weren't sampled
+func (l *Logger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// PrintSticky inserts s into the Buffer as a sticky entry, which is never evicted by
+// overflow. If the Buffer doesn't support sticky entries, s is printed normally
+func (l *Logger) PrintSticky(p LogPriority, s string) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	if sw, ok := l.buf.(StickyWriter); ok {
+		l.writeWith(p, []byte(s), sw.PWriteSticky)
+		return
+	}
+	l.writeWith(p, []byte(s), l.buf.PWrite)
+}
+
+// PrintSync prints s with priority p and, if the Buffer implements Syncer, returns
+// only once the Buffer reports the entry as durably stored. The durability guarantee
+// depends on the Buffer; for in-memory Buffers such as RingBuffer, PrintSync is
+// equivalent to Print. It returns the first error from writing or syncing, if any
+func (l *Logger) PrintSync(p LogPriority, s string) error {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	if err := l.writeWith(p, []byte(s), l.buf.PWrite); err != nil {
+		return err
+	}
+	if sy, ok := l.buf.(Syncer); ok {
+		return sy.Sync()
+	}
+	return nil
+}
+
+// PrintErrChain prints err.Error() with priority p and, if the Buffer implements
+// FieldWriter, stores each error in err's chain, as returned by errors.Unwrap, as a
+// field of the entry. The field "err.0" holds err.Error(), "err.1" holds the error it
+// wraps, and so on, so a structured consumer such as PopEntry sees every layer of the
+// cause chain. Errors that wrap several errors, like those from errors.Join, end the
+// chain. For other Buffers, or when flushing as text, only err.Error() is kept
+// A nil err prints nothing
+func (l *Logger) PrintErrChain(p LogPriority, err error) {
+	if err == nil {
+		return
 	}
 
-	r.buf[r.highP] = r.buf[r.highP].Prev()
-	b, ok := r.buf[r.highP].Value.([]byte)
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	fw, ok := l.buf.(FieldWriter)
 	if !ok {
-		return "", fmt.Errorf("pop type assertion failed")
+		l.writeWith(p, []byte(err.Error()), l.buf.PWrite)
+		return
 	}
-	var ret string
-	if priPrefix {
-		ret = fmt.Sprintf("%s %s", PriorityString(LogPriority(r.highP)), string(b))
-	} else {
-		ret = string(b)
+
+	fields := make(map[string]string)
+	for k, e := 0, err; e != nil; k, e = k+1, errors.Unwrap(e) {
+		fields[fmt.Sprintf("err.%d", k)] = e.Error()
 	}
+	l.writeWith(p, []byte(err.Error()), func(p LogPriority, b []byte) (int, error) {
+		return fw.PWriteFields(p, b, fields)
+	})
+}
 
-	r.buf[r.highP].Value = nil
+// BindContextKey makes PrintCtx attach the value stored in its context under key as
+// the entry field named field. Binding a key again replaces its field name
+func (l *Logger) BindContextKey(key interface{}, field string) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
 
-	// update highP
-	for i := r.highP; i >= 0; i-- {
-		if _, ok := r.buf[i]; ok && r.buf[i].Prev().Value != nil {
-			r.highP = i
-			break
+	if l.ctxKeys == nil {
+		l.ctxKeys = make(map[interface{}]string)
+	}
+	l.ctxKeys[key] = field
+}
+
+// PrintCtx prints msg with priority p and, if the Buffer implements FieldWriter,
+// attaches the values ctx holds for the keys bound with BindContextKey as fields of
+// the entry, formatted with fmt.Sprint. Keys that ctx holds no value for are skipped
+func (l *Logger) PrintCtx(ctx context.Context, p LogPriority, msg string) {
+	l.confLock.RLock()
+	var fields map[string]string
+	for key, field := range l.ctxKeys {
+		if v := ctx.Value(key); v != nil {
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			fields[field] = fmt.Sprint(v)
 		}
 	}
+	l.confLock.RUnlock()
+
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	fw, ok := l.buf.(FieldWriter)
+	if !ok || fields == nil {
+		l.writeWith(p, []byte(msg), l.buf.PWrite)
+		return
+	}
+	l.writeWith(p, []byte(msg), func(p LogPriority, b []byte) (int, error) {
+		return fw.PWriteFields(p, b, fields)
+	})
+}
+
+// write passes b through the Logger's output settings and into its Buffer
+func (l *Logger) write(p LogPriority, b []byte) error {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
 
-	return ret, nil
+	return l.writeWith(p, b, l.buf.PWrite)
 }
 
-// Write write a slice of bytes (p) into it's ring buffer
-func (r *RingBuffer) Write(b []byte) (int, error) {
-	return r.PWrite(r.GetPriority(), b)
+// writeWith passes b through the Logger's output settings and stores it using store
+// The caller must hold bufLock for reading and release it with releaseBuf
+func (l *Logger) writeWith(p LogPriority, b []byte, store func(LogPriority, []byte) (int, error)) error {
+	b, buffer, outErr := l.emit(p, b)
+	if !buffer {
+		return outErr
+	}
+
+	if _, err := store(p, b); err != nil {
+		return err
+	}
+	l.signalDrains()
+	if err := l.flushThreshold(); err != nil {
+		return err
+	}
+	return outErr
 }
 
-// PWrite writes to the ring buffer with priority p
-func (r *RingBuffer) PWrite(p LogPriority, b []byte) (int, error) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+// emit applies the Logger's closed, pause, newline, and output settings to b, writing it to
+// the output writer if one is set. It returns b as it should be stored, whether it
+// should be stored in the Buffer at all, and the output writer's error, if any
+// The caller must hold bufLock for reading
+func (l *Logger) emit(p LogPriority, b []byte) ([]byte, bool, error) {
+	l.confLock.RLock()
+	out, mode, logPanics, paused := l.out, l.outMode, l.logPanics, l.paused
+	normalize := l.normalize
+	sampleP, sampleN := l.sampleP, l.sampleN
+	decorate := l.prioFormats[p]
+	l.confLock.RUnlock()
 
-	i := int(p)
-	if i > r.highP {
-		r.highP = i
+	if l.closed.Load() {
+		l.dropped.Add(1)
+		return b, false, ErrLoggerClosed
 	}
+	if paused {
+		l.dropped.Add(1)
+		return b, false, nil
+	}
+	if sampleN > 1 && l.lessPriority(p, sampleP) && (l.sampled.Add(1)-1)%int64(sampleN) != 0 {
+		l.dropped.Add(1)
+		return b, false, nil
+	}
+	if normalize {
+		b = normalizeNewlines(b)
+	}
+	if decorate != nil {
+		msg := string(b)
+		b = []byte(safeFormat(func() string { return decorate(msg) }))
+	}
+	if out == nil {
+		return b, true, nil
+	}
+
+	line := l.formatEntryOr(Entry{
+		Message:  string(b),
+		Priority: p,
+		Time:     time.Now(),
+	}, outputFormat)
+
+	var outErr error
+	if panicErr := safeCall(func() { _, outErr = out.Write(line) }); panicErr != nil {
+		if logPanics {
+			l.buf.PWrite(Critical, []byte(panicErr.Error()))
+		}
+		outErr = panicErr
+	}
+	return b, mode != OutputOnly, outErr
+}
 
-	if r.buf[i] == nil {
-		r.buf[i] = ring.New(r.bufCap)
+// lessPriority reports whether a is a lower priority than b under the Buffer's priority
+// comparator, or under the default ordering if the Buffer doesn't have one
+// The caller must hold bufLock for reading
+func (l *Logger) lessPriority(a, b LogPriority) bool {
+	if pc, ok := l.buf.(priorityComparer); ok {
+		return pc.lessPriority(a, b)
 	}
+	return numericLess(a, b)
+}
 
-	r.buf[i].Value = b
-	r.buf[i] = r.buf[i].Next()
+// SetAdaptiveSampling keeps every entry printed with priority threshold or higher and
+// 1 in n of the entries below it, starting with the first. Priorities are compared
+// using the Buffer's priority comparator, if it has one. Entries that aren't kept
+// are dropped before reaching the output writer or the Buffer and are counted by
+// Dropped. An n of 1 or less keeps every entry
+func (l *Logger) SetAdaptiveSampling(threshold LogPriority, n int) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
 
-	return len(b), nil
+	l.sampleP = threshold
+	l.sampleN = max(n, 0)
+	l.sampled.Store(0)
+}
+
+// SetPriorityFormat sets a function that rewrites each message printed with priority
+// p before it's written to the output writer and the Buffer, so the stored entry
+// carries the decoration. Messages of other priorities are unchanged. The function
+// sees the message after newline normalization. If it panics, a placeholder naming
+// the panic is stored instead. Passing nil removes the function for p
+func (l *Logger) SetPriorityFormat(p LogPriority, fn func(msg string) string) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	if fn == nil {
+		delete(l.prioFormats, p)
+		return
+	}
+	if l.prioFormats == nil {
+		l.prioFormats = make(map[LogPriority]func(string) string)
+	}
+	l.prioFormats[p] = fn
+}
+
+// SetNewlineNormalization sets whether the Logger rewrites "\r\n" and lone "\r" line
+// endings in printed entries to "\n" before writing them to the output writer and the
+// Buffer, so flushed output is consistent regardless of where entries came from
+// Entries written directly to the Buffer are not normalized
+func (l *Logger) SetNewlineNormalization(enabled bool) {
+	l.confLock.Lock()
+	defer l.confLock.Unlock()
+
+	l.normalize = enabled
+}
+
+// normalizeNewlines returns b with "\r\n" and lone "\r" replaced by "\n". b is
+// returned as is if it contains no carriage returns
+func normalizeNewlines(b []byte) []byte {
+	if bytes.IndexByte(b, '\r') < 0 {
+		return b
+	}
+
+	out := make([]byte, 0, len(b))
+	for k := 0; k < len(b); k++ {
+		if b[k] != '\r' {
+			out = append(out, b[k])
+			continue
+		}
+		if k+1 < len(b) && b[k+1] == '\n' {
+			k++
+		}
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// PrintBatch prints each of msgs with priority p, storing them in the Buffer with a
+// single call to PWriteBatch. Each message is handled by the Logger's output settings
+// as Print would handle it. It returns the first error encountered, if any
+func (l *Logger) PrintBatch(p LogPriority, msgs []string) error {
+	_, err := l.printBatch(p, msgs)
+	return err
+}
+
+// printBatch implements PrintBatch, also returning the number of messages the Buffer
+// accepted
+func (l *Logger) printBatch(p LogPriority, msgs []string) (int, error) {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	var firstErr error
+	batch := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		b, buffer, err := l.emit(p, []byte(msg))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if buffer {
+			batch = append(batch, b)
+		}
+	}
+	if len(batch) == 0 {
+		return 0, firstErr
+	}
+
+	n, err := l.buf.PWriteBatch(p, batch)
+	if n > 0 {
+		l.signalDrains()
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := l.flushThreshold(); err != nil {
+		return n, err
+	}
+	return n, firstErr
+}
+
+// Import stores each of lines in the Buffer with priority p, in order, so the Buffer
+// can be seeded with earlier log output, such as the tail of a log file, on startup
+// Lines are printed as PrintBatch prints them, so the Logger's output settings apply
+// and they're stored with a single call to PWriteBatch. Import returns the number of
+// lines the Buffer accepted, which is less than len(lines) if the Buffer rejected some
+// or some were dropped by pausing or sampling, and the first error encountered, if
+// any. Lines that are accepted may still overwrite each other if there are more than
+// the Buffer holds. Once the Logger is closed, Import returns 0 and ErrLoggerClosed
+func (l *Logger) Import(lines []string, p LogPriority) (int, error) {
+	return l.printBatch(p, lines)
+}
+
+// GetBuffer returns the reference to the Logger's internal Buffer
+func (l *Logger) GetBuffer() Buffer {
+	l.bufLock.RLock()
+	defer l.bufLock.RUnlock()
+
+	return l.buf
+}
+
+// SwapBuffer transfers the entries in the Logger's Buffer into b, like Transfer, makes
+// b the Logger's Buffer, and returns the previous Buffer. Writes and flushes wait for
+// the swap to finish, so every entry ends up in either the old Buffer or b, never in
+// between
+// If a write to b fails, such as because b is full, the swap doesn't happen: every
+// entry is put back in the Logger's Buffer, which it keeps, and the error is returned
+// b may still hold copies of the entries written before the failure
+// SwapBuffer panics if b is nil
+func (l *Logger) SwapBuffer(b Buffer) (Buffer, error) {
+	if b == nil {
+		panic("plog: SwapBuffer called with a nil Buffer")
+	}
+
+	l.bufLock.Lock()
+	defer l.bufLock.Unlock()
+
+	old := l.buf
+	if _, err := transfer(b, old, true); err != nil {
+		return nil, err
+	}
+	l.buf = b
+	return old, nil
+}
+
+// Clone returns a new Logger with the same configuration as l and a new, empty Buffer
+// of the same type and capacity as l's. The entries in l's Buffer are not cloned, nor
+// are its flush statistics, drop count, Count counters, or DrainMin subscriptions
+// RingBuffers keep their options, SliceBuffers are cloned likewise, and DedupBuffers
+// and BatchingBuffers keep their settings and wrap a clone of their Buffer
+// Clone returns an error if the Buffer can't be cloned, such as a FileBuffer, which is
+// tied to its file, or a custom Buffer
+func (l *Logger) Clone() (*Logger, error) {
+	l.bufLock.RLock()
+	var b Buffer
+	if c, ok := l.buf.(emptyCloner); ok {
+		b = c.cloneEmpty()
+	}
+	l.bufLock.RUnlock()
+	if b == nil {
+		return nil, fmt.Errorf("plog: can't clone a Logger whose Buffer is a %T", l.GetBuffer())
+	}
+
+	c := NewLogger(b)
+
+	l.confLock.RLock()
+	c.format = l.format
+	c.out = l.out
+	c.outMode = l.outMode
+	c.logPanics = l.logPanics
+	c.flushP = l.flushP
+	c.flushW = l.flushW
+	if l.dests != nil {
+		c.dests = make(map[LogPriority]io.Writer, len(l.dests))
+		for p, w := range l.dests {
+			c.dests[p] = w
+		}
+	}
+	c.destDef = l.destDef
+	if l.levels != nil {
+		c.levels = make(map[string]LogPriority, len(l.levels))
+		for name, p := range l.levels {
+			c.levels[name] = p
+		}
+	}
+	c.warnLevel = l.warnLevel
+	c.deadFn = l.deadFn
+	c.paused = l.paused
+	c.flushOrder = l.flushOrder
+	c.dropMarks = l.dropMarks
+	c.normalize = l.normalize
+	c.writeEmpty = l.writeEmpty
+	c.strictLock = l.strictLock
+	c.binarySafe = l.binarySafe
+	c.transform = l.transform
+	c.sampleP = l.sampleP
+	c.sampleN = l.sampleN
+	if l.prioFormats != nil {
+		c.prioFormats = make(map[LogPriority]func(string) string, len(l.prioFormats))
+		for p, fn := range l.prioFormats {
+			c.prioFormats[p] = fn
+		}
+	}
+	if l.ctxKeys != nil {
+		c.ctxKeys = make(map[interface{}]string, len(l.ctxKeys))
+		for key, field := range l.ctxKeys {
+			c.ctxKeys[key] = field
+		}
+	}
+	l.confLock.RUnlock()
+
+	l.sessLock.RLock()
+	c.closeTimeout = l.closeTimeout
+	l.sessLock.RUnlock()
+
+	return c, nil
+}
+
+// Buffer allows you to define custom write and output behavior while still implementing
+// the io.Writer interface for use with other packages
+//
+// Available reports how many more entries the Buffer can accept before it starts
+// overwriting or rejecting entries. Unbounded implementations should return -1
+//
+// PWriteBatch writes several entries at one priority and returns the number accepted
+// Implementations that lock should hold the lock once for the whole batch
+//
+// Len reports how many entries are buffered, so callers can gauge how full the Buffer
+// is without popping from it
+type Buffer interface {
+	Pop(bool) (string, error)
+	Write([]byte) (int, error)
+	PWrite(LogPriority, []byte) (int, error)
+	PWriteBatch(LogPriority, [][]byte) (int, error)
+	GetPriority() LogPriority
+	SetPriority(LogPriority)
+	Available() int
+	Len() int
+}
+
+// Transfer pops every entry from src and writes it to dst with the priority it was
+// stored at, returning the number of entries moved. Entries are written to dst oldest
+// first, so dst pops them in the same order src would have
+// Write times and fields are kept if dst is a RingBuffer, and fields are kept if dst
+// implements FieldWriter. Sticky entries of a RingBuffer src are moved too, and stay
+// sticky if dst implements StickyWriter
+// If a write to dst fails, Transfer stops and returns the error. The entries that
+// weren't written to dst are put back in src
+func Transfer(dst, src Buffer) (int, error) {
+	return transfer(dst, src, false)
+}
+
+// transfer implements Transfer. If restoreAll is set and a write fails, every entry
+// taken from src is put back, including the ones already written to dst
+func transfer(dst, src Buffer, restoreAll bool) (int, error) {
+	taken := takeEntries(src)
+	for i, e := range taken {
+		if err := writeEntry(dst, e); err != nil {
+			if !restoreAll {
+				taken = taken[i:]
+			}
+			for _, e := range taken {
+				writeEntry(src, e)
+			}
+			return i, err
+		}
+	}
+	return len(taken), nil
+}
+
+// takenEntry is an entry removed from a Buffer by takeEntries
+type takenEntry struct {
+	Entry
+	sticky bool
+}
+
+// entryTaker is implemented by Buffers that can remove all of their entries at once,
+// including ones that Pop doesn't return, such as sticky entries
+type entryTaker interface {
+	takeEntries() []takenEntry
+}
+
+// entryRestorer is implemented by Buffers that can store an entry with the write time
+// and fields it was taken with
+type entryRestorer interface {
+	restoreEntry(e Entry, sticky bool) error
+}
+
+// takeEntries removes every entry from b and returns them oldest first
+func takeEntries(b Buffer) []takenEntry {
+	if et, ok := b.(entryTaker); ok {
+		return et.takeEntries()
+	}
+
+	var popped []takenEntry
+	for {
+		e, err := popEntry(b)
+		if err != nil {
+			break
+		}
+		popped = append(popped, takenEntry{Entry: e})
+	}
+
+	taken := make([]takenEntry, 0, len(popped))
+	for i := len(popped) - 1; i >= 0; i-- {
+		taken = append(taken, popped[i])
+	}
+	return taken
+}
+
+// writeEntry stores e in b, keeping as much of its metadata as b supports
+func writeEntry(b Buffer, e takenEntry) error {
+	if er, ok := b.(entryRestorer); ok {
+		return er.restoreEntry(e.Entry, e.sticky)
+	}
+
+	var err error
+	data := []byte(e.Message)
+	if sw, ok := b.(StickyWriter); ok && e.sticky {
+		_, err = sw.PWriteSticky(e.Priority, data)
+	} else if fw, ok := b.(FieldWriter); ok && len(e.Fields) > 0 {
+		_, err = fw.PWriteFields(e.Priority, data, e.Fields)
+	} else {
+		_, err = b.PWrite(e.Priority, data)
+	}
+	return err
+}
+
+// StickyWriter is implemented by Buffers that can store sticky entries, which are
+// exempt from overflow eviction
+type StickyWriter interface {
+	PWriteSticky(LogPriority, []byte) (int, error)
+}
+
+// FieldWriter is implemented by Buffers that can store structured fields alongside an
+// entry, which are returned in Entry.Fields
+type FieldWriter interface {
+	PWriteFields(LogPriority, []byte, map[string]string) (int, error)
+}
+
+// Syncer is implemented by Buffers that can flush written entries to durable storage
+// Sync returns once every entry written before the call is durably stored
+type Syncer interface {
+	Sync() error
+}
+
+// DropCounter is implemented by Buffers that count the entries they lose to overflow
+type DropCounter interface {
+	TakeDropped() map[LogPriority]int
+}
+
+// MinPopper is implemented by Buffers that can pop entries selectively by priority
+type MinPopper interface {
+	PopMin(LogPriority) (Entry, error)
+}
+
+// Drainer is implemented by Buffers that can pop every entry at once, in the order
+// Pop would return them, under a single lock acquisition
+type Drainer interface {
+	DrainAll() []string
+}
+
+// emptyCloner is implemented by Buffers that can allocate an empty Buffer with the
+// same settings, for Logger.Clone. cloneEmpty returns nil if that isn't possible
+type emptyCloner interface {
+	cloneEmpty() Buffer
+}
+
+// remover is implemented by Buffers that can delete a stored entry
+type remover interface {
+	removeEntry(LogPriority, []byte) bool
+}
+
+// priorityComparer is implemented by Buffers whose priority ordering can be changed,
+// such as RingBuffer
+type priorityComparer interface {
+	lessPriority(a, b LogPriority) bool
+}
+
+// RingBuffer uses a ring buffer to store logs and manage memory usage
+// This buffer optimizes for write performance over read performance
+type RingBuffer struct {
+	p      LogPriority
+	bufCap int
+	buf    []*prioRing       // rings for priorities in [0, len(buf)), indexed by priority
+	sparse map[int]*prioRing // rings for priorities outside of buf's range
+	prios  []int             // allocated priorities in ascending order according to less
+	total  int               // number of stored entries across all priorities
+	size   int               // total length of stored entries, including sticky ones
+	max    int               // maximum value of total, or 0 for no limit
+	lock   *sync.Mutex
+	highP  int // highest allocated priority with entries, never a bare value
+	less   func(a, b LogPriority) bool
+
+	initSlots int // number of slots new rings start with, or 0 for bufCap
+
+	framing bool   // whether Write and WriteByte assemble lines
+	line    []byte // pending line while framing
+	maxLine int    // longest line ReadFrom stores, or 0 for DefaultMaxLineLength
+
+	spill   io.Writer // receives entries evicted by overflow
+	archive *prioRing // most recent entries evicted by overflow, or nil
+	policy  OverflowPolicy
+	score   func(Entry, time.Duration) float64 // eviction scorer, or nil
+
+	bound  bool        // whether Write uses boundP rather than p
+	boundP LogPriority // priority used by Write when bound
+
+	zeroCopy bool   // whether written slices are stored without copying
+	seq      uint64 // sequence number of the most recent write
+
+	intern map[string][]byte // stored bodies shared by identical entries, or nil
+
+	notify chan struct{} // closed when an entry is stored, or nil if nobody is waiting
+
+	fullFns   map[LogPriority]func() // callbacks for priorities whose ring fills up
+	fullQueue []func()               // callbacks to run once r.lock is released
+
+	dropped map[LogPriority]int // entries lost to overflow since the last TakeDropped
+
+	weights map[LogPriority]int // drain weights for fair popping, or nil for strict
+	drained map[LogPriority]int // entries popped per priority in the current round
+
+	promoteAge time.Duration // age at which entries are popped oldest first, or 0
+
+	sticky    []*entry // sticky entries in write order
+	stickyCap int      // maximum number of sticky entries
+	stickyPop bool     // whether Pop returns sticky entries
+
+	logPanics     bool     // whether recovered panics are written as entries
+	loggingPanics bool     // whether recovered panics are being written
+	panics        []string // recovered panics waiting to be written
+}
+
+// DefaultStickyQuota is the number of sticky entries a RingBuffer holds by default
+const DefaultStickyQuota = 8
+
+// internLimit is the number of distinct bodies a RingBuffer's interning pool holds
+// before it is cleared
+const internLimit = 1024
+
+// denseLimit is the number of priorities, starting from 0, whose rings are indexed
+// directly rather than stored in a map
+const denseLimit = 64
+
+// prioRing holds the ring for a single priority, which points at the next slot to be
+// written, and the number of entries stored in it
+type prioRing struct {
+	r     *ring.Ring
+	n     int
+	slots int // number of slots in r, which is less than the capacity after Compact
+
+	origin *ring.Ring // slot that Inspect numbers the others from, or nil
+}
+
+// walk calls fn with each slot of pr that holds an entry, from newest to oldest,
+// skipping unused slots, until fn returns false
+func (pr *prioRing) walk(fn func(rg *ring.Ring) bool) {
+	rg := pr.r
+	for found := 0; found < pr.n; {
+		rg = rg.Prev()
+		if rg.Value == nil {
+			continue
+		}
+		found++
+		if !fn(rg) {
+			return
+		}
+	}
+}
+
+// OverflowPolicy determines what a RingBuffer does with a write that would exceed
+// its capacity
+type OverflowPolicy int
+
+const (
+	// OverwriteOldest evicts the oldest entry to make room for the new one
+	OverwriteOldest OverflowPolicy = iota
+	// RejectNewest keeps the stored entries and fails the write with ErrBufferFull
+	RejectNewest
+)
+
+// RingBufferOption configures a RingBuffer at construction
+type RingBufferOption func(*RingBuffer)
+
+// WithWritePriority binds Write (and line framing commits) to priority p rather than
+// the RingBuffer's default priority. Combined with the RejectNewest overflow policy,
+// this makes the RingBuffer usable as an io.Writer that reports ErrBufferFull
+// whenever an entry could not be stored
+func WithWritePriority(p LogPriority) RingBufferOption {
+	return func(r *RingBuffer) {
+		r.bound = true
+		r.boundP = p
+	}
+}
+
+// WithZeroCopy makes the RingBuffer store the slices passed to Write and PWrite
+// directly rather than copying them. This avoids an allocation and copy per write, but
+// callers must not modify a slice after writing it, including by reusing the buffer
+// it came from. Logger.Append and append sessions are not safe to use with this
+// option, because they reuse their append buffers
+func WithZeroCopy() RingBufferOption {
+	return func(r *RingBuffer) {
+		r.zeroCopy = true
+	}
+}
+
+// NewRingBuffer initializes a new RingBuffer struct with the given LogPriority and
+// buffer size and returns a reference to it
+func NewRingBuffer(p LogPriority, size int, opts ...RingBufferOption) *RingBuffer {
+	r := &RingBuffer{
+		p:      p,
+		bufCap: size,
+		sparse: make(map[int]*prioRing),
+		lock:   &sync.Mutex{},
+		highP:  0,
+		less:   numericLess,
+
+		stickyCap: DefaultStickyQuota,
+		stickyPop: true,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// cloneEmpty returns a RingBuffer with the same capacity and settings as r and no
+// entries
+func (r *RingBuffer) cloneEmpty() Buffer {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c := NewRingBuffer(r.p, r.bufCap)
+	c.max = r.max
+	c.less = r.less
+	c.initSlots = r.initSlots
+	c.framing = r.framing
+	c.maxLine = r.maxLine
+	c.spill = r.spill
+	if r.fullFns != nil {
+		c.fullFns = make(map[LogPriority]func(), len(r.fullFns))
+		for p, fn := range r.fullFns {
+			c.fullFns[p] = fn
+		}
+	}
+	if r.archive != nil {
+		c.archive = &prioRing{r: ring.New(r.archive.r.Len())}
+	}
+	c.policy = r.policy
+	c.score = r.score
+	c.bound = r.bound
+	c.boundP = r.boundP
+	c.zeroCopy = r.zeroCopy
+	if r.intern != nil {
+		c.intern = make(map[string][]byte)
+	}
+	if r.weights != nil {
+		c.weights = make(map[LogPriority]int, len(r.weights))
+		for p, w := range r.weights {
+			c.weights[p] = w
+		}
+		c.drained = make(map[LogPriority]int)
+	}
+	c.promoteAge = r.promoteAge
+	c.stickyCap = r.stickyCap
+	c.stickyPop = r.stickyPop
+	c.logPanics = r.logPanics
+	return c
+}
+
+// NewGrowingRingBuffer initializes a RingBuffer whose priority rings start with initial
+// slots and double in size each time they fill, rather than overwriting their oldest
+// entry, until they reach max slots. From then on, the overflow policy applies as it
+// does for a RingBuffer created by NewRingBuffer with a size of max. Compact shrinks
+// the rings again once a burst has passed
+// initial is clamped to the range [1, max]
+func NewGrowingRingBuffer(p LogPriority, initial, max int, opts ...RingBufferOption) *RingBuffer {
+	r := NewRingBuffer(p, max, opts...)
+	r.initSlots = min(initial, max)
+	if r.initSlots < 1 {
+		r.initSlots = 1
+	}
+	return r
+}
+
+// PWriteSticky writes a sticky entry with priority p
+// Sticky entries are kept apart from the priority rings, so overflow never evicts
+// them and they don't count toward the limit set by SetMaxEntries. Instead, they have
+// their own quota, set by SetStickyQuota; once it is reached, PWriteSticky returns
+// ErrBufferFull
+func (r *RingBuffer) PWriteSticky(p LogPriority, b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.sticky) >= r.stickyCap {
+		r.drop(p)
+		return 0, ErrBufferFull
+	}
+
+	r.size += len(b)
+	r.sticky = append(r.sticky, &entry{
+		data: r.own(b),
+		p:    p,
+		t:    time.Now(),
+		seq:  r.nextSeq(),
+	})
+	r.signal()
+	return len(b), nil
+}
+
+// PWriteFields writes b with priority p, like PWrite, and stores a copy of fields with
+// it. The fields are returned by PopEntry in Entry.Fields
+func (r *RingBuffer) PWriteFields(p LogPriority, b []byte, fields map[string]string) (int, error) {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	var copied map[string]string
+	if len(fields) > 0 {
+		copied = make(map[string]string, len(fields))
+		for k, v := range fields {
+			copied[k] = v
+		}
+	}
+
+	return writeResult(b, r.insert(&entry{
+		data:   r.own(b),
+		p:      p,
+		t:      time.Now(),
+		fields: copied,
+		seq:    r.nextSeq(),
+	}))
+}
+
+// takeEntries removes every entry, sticky or not, and returns them oldest first, with
+// the ring entries before the sticky ones
+func (r *RingBuffer) takeEntries() []takenEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var popped []*entry
+	for {
+		e, err := r.popFrom(0)
+		if err != nil {
+			break
+		}
+		popped = append(popped, e)
+	}
+	clear(r.drained)
+
+	taken := make([]takenEntry, 0, len(popped)+len(r.sticky))
+	for i := len(popped) - 1; i >= 0; i-- {
+		taken = append(taken, takenEntry{Entry: popped[i].export()})
+	}
+	for _, e := range r.sticky {
+		taken = append(taken, takenEntry{Entry: e.export(), sticky: true})
+		r.size -= len(e.data)
+	}
+	r.sticky = nil
+	return taken
+}
+
+// restoreEntry stores e with its write time and fields, as a sticky entry if sticky
+// is set, and gives it a new sequence number
+func (r *RingBuffer) restoreEntry(e Entry, sticky bool) error {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	var fields map[string]string
+	if len(e.Fields) > 0 {
+		fields = make(map[string]string, len(e.Fields))
+		for k, v := range e.Fields {
+			fields[k] = v
+		}
+	}
+	stored := &entry{
+		data:   r.own([]byte(e.Message)),
+		p:      e.Priority,
+		t:      e.Time,
+		fields: fields,
+		seq:    r.nextSeq(),
+	}
+	if stored.t.IsZero() {
+		stored.t = time.Now()
+	}
+
+	if !sticky {
+		return r.insert(stored)
+	}
+	if len(r.sticky) >= r.stickyCap {
+		r.drop(e.Priority)
+		return ErrBufferFull
+	}
+	r.size += len(stored.data)
+	r.sticky = append(r.sticky, stored)
+	r.signal()
+	return nil
+}
+
+// Sync implements Syncer. RingBuffer is held in memory, so Sync does nothing
+func (r *RingBuffer) Sync() error {
+	return nil
+}
+
+// signal wakes every goroutine waiting in TryPopWait
+// The caller must hold r.lock
+func (r *RingBuffer) signal() {
+	if r.notify != nil {
+		close(r.notify)
+		r.notify = nil
+	}
+}
+
+// TryPopWait behaves like Pop, but if the RingBuffer is empty, it waits up to timeout
+// for an entry to be written rather than returning ErrBufferEmpty. It returns
+// ErrTimeout if no entry could be popped in time. Several goroutines may wait at once;
+// each write wakes all of them, and those that don't get the entry keep waiting
+// Sticky entries only wake waiters if Pop returns them; see SetStickyPop
+func (r *RingBuffer) TryPopWait(timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		r.lock.Lock()
+		e, err := r.popEntry()
+		if err == nil {
+			r.lock.Unlock()
+			return string(e.data), nil
+		}
+		if r.notify == nil {
+			r.notify = make(chan struct{})
+		}
+		notify := r.notify
+		r.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return "", ErrTimeout
+		}
+	}
+}
+
+// SetStickyQuota sets the maximum number of sticky entries the RingBuffer holds
+// Lowering the quota does not discard sticky entries already stored
+func (r *RingBuffer) SetStickyQuota(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stickyCap = n
+}
+
+// SetStickyPop sets whether Pop returns sticky entries
+// If enabled, which is the default, sticky entries are returned once every other entry
+// has been popped, in the same priority order as other entries. If disabled, sticky
+// entries are never popped and remain in the RingBuffer until it is Reset
+func (r *RingBuffer) SetStickyPop(enabled bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stickyPop = enabled
+}
+
+// popSticky removes and returns the highest priority, newest sticky entry
+// The caller must hold r.lock
+func (r *RingBuffer) popSticky() (*entry, error) {
+	j := r.stickyTop()
+	if j < 0 {
+		return nil, ErrBufferEmpty
+	}
+
+	e := r.sticky[j]
+	r.sticky = append(r.sticky[:j], r.sticky[j+1:]...)
+	r.size -= len(e.data)
+	return e, nil
+}
+
+// numericLess is the default priority comparator, under which larger values are
+// higher priority
+func numericLess(a, b LogPriority) bool {
+	return a < b
+}
+
+// SetPriorityComparator sets the ordering used to decide which priorities are popped
+// first. less reports whether a is a lower priority than b; entries with higher
+// priorities are popped first, and the lowest priorities are evicted first when the
+// entry limit is reached. By default, larger values are higher priority. For
+// syslog-style severities, where smaller values are more urgent, use
+//
+//	r.SetPriorityComparator(func(a, b LogPriority) bool { return a > b })
+//
+// If less panics, the panic is recovered and the default ordering is used for that
+// comparison. Passing nil restores the default ordering
+func (r *RingBuffer) SetPriorityComparator(less func(a, b LogPriority) bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.less = numericLess
+	if less != nil {
+		r.less = r.safeLess(less)
+	}
+
+	sort.Slice(r.prios, func(i, j int) bool {
+		return r.less(LogPriority(r.prios[i]), LogPriority(r.prios[j]))
+	})
+	for j := len(r.prios) - 1; j >= 0; j-- {
+		if r.count(r.prios[j]) > 0 {
+			r.highP = r.prios[j]
+			break
+		}
+	}
+}
+
+// lessPriority reports whether a is a lower priority than b under the RingBuffer's
+// priority comparator
+func (r *RingBuffer) lessPriority(a, b LogPriority) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.less(a, b)
+}
+
+// searchPrios returns the index of the first allocated priority that is not lower
+// than i, which is where i belongs in r.prios
+// The caller must hold r.lock
+func (r *RingBuffer) searchPrios(i int) int {
+	return sort.Search(len(r.prios), func(j int) bool {
+		return !r.less(LogPriority(r.prios[j]), LogPriority(i))
+	})
+}
+
+// stickyTop returns the index of the sticky entry Pop would return next, or -1 if Pop
+// won't return a sticky entry
+// The caller must hold r.lock
+func (r *RingBuffer) stickyTop() int {
+	if !r.stickyPop || len(r.sticky) == 0 {
+		return -1
+	}
+
+	j := len(r.sticky) - 1
+	for k := j - 1; k >= 0; k-- {
+		if r.less(r.sticky[j].p, r.sticky[k].p) {
+			j = k
+		}
+	}
+	return j
+}
+
+// SetOverflowPolicy sets how the RingBuffer handles writes that would exceed either
+// a priority ring's capacity or the limit set by SetMaxEntries
+func (r *RingBuffer) SetOverflowPolicy(policy OverflowPolicy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.policy = policy
+}
+
+// writePriority returns the priority used by Write
+// The caller must hold r.lock
+func (r *RingBuffer) writePriority() LogPriority {
+	if r.bound {
+		return r.boundP
+	}
+	return r.p
+}
+
+// getRing returns the prioRing for priority i, or nil if it hasn't been allocated
+// The caller must hold r.lock
+func (r *RingBuffer) getRing(i int) *prioRing {
+	if i >= 0 && i < len(r.buf) {
+		return r.buf[i]
+	}
+	return r.sparse[i]
+}
+
+// allocRing allocates and returns the prioRing for priority i
+// The caller must hold r.lock
+func (r *RingBuffer) allocRing(i int) *prioRing {
+	slots := r.bufCap
+	if r.initSlots > 0 {
+		slots = r.initSlots
+	}
+	pr := &prioRing{r: ring.New(slots), slots: slots}
+	pr.origin = pr.r
+	if i >= 0 && i < denseLimit {
+		for len(r.buf) <= i {
+			r.buf = append(r.buf, nil)
+		}
+		r.buf[i] = pr
+	} else {
+		r.sparse[i] = pr
+	}
+
+	j := r.searchPrios(i)
+	r.prios = append(r.prios, 0)
+	copy(r.prios[j+1:], r.prios[j:])
+	r.prios[j] = i
+
+	return pr
+}
+
+// count returns the number of entries stored with priority i
+// The caller must hold r.lock
+func (r *RingBuffer) count(i int) int {
+	if pr := r.getRing(i); pr != nil {
+		return pr.n
+	}
+	return 0
+}
+
+// GetPriority returns the RingBuffer's LogPriority
+func (r *RingBuffer) GetPriority() LogPriority {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.p
+}
+
+// SetPriority sets the RingBuffer's default priority
+func (r *RingBuffer) SetPriority(p LogPriority) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.p = p
+}
+
+// Bytes returns the total length of the entries stored in the RingBuffer, including
+// sticky entries
+func (r *RingBuffer) Bytes() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.size
+}
+
+// MemStats returns the number of stored entries, their total length, and the number
+// of entries the RingBuffer can hold, read together under a single lock acquisition so
+// that they are consistent with each other. Sticky entries are counted, and their
+// quota is included in capEntries. Since each priority has its own ring, capEntries
+// covers the priorities written so far and is limited by SetMaxEntries, if set
+func (r *RingBuffer) MemStats() (entries int, bytes int, capEntries int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	capEntries = r.bufCap * len(r.prios)
+	if r.max > 0 {
+		capEntries = min(capEntries, r.max)
+	}
+	return r.total + len(r.sticky), r.size, capEntries + r.stickyCap
+}
+
+// Available returns the number of entries that can be written with Write before the
+// oldest entry at that priority is overwritten, or before an entry is evicted if the
+// limit set by SetMaxEntries is lower
+func (r *RingBuffer) Available() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	n := r.bufCap - r.count(int(r.writePriority()))
+	if r.max > 0 {
+		n = min(n, max(r.max-r.total, 0))
+	}
+	return n
+}
+
+// Len returns the number of entries stored in the RingBuffer, including sticky
+// entries, so a Len of 0 means Pop has nothing to return
+func (r *RingBuffer) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.total + len(r.sticky)
+}
+
+// LenPriority returns the number of entries stored with priority p
+func (r *RingBuffer) LenPriority(p LogPriority) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.count(int(p))
+}
+
+// HasPriority returns whether any entry with priority p is buffered
+func (r *RingBuffer) HasPriority(p LogPriority) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.count(int(p)) > 0
+}
+
+// HasAtLeast returns whether any entry with priority p or higher is buffered, as
+// ordered by the RingBuffer's priority comparator
+func (r *RingBuffer) HasAtLeast(p LogPriority) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for j := r.searchPrios(int(p)); j < len(r.prios); j++ {
+		if r.count(r.prios[j]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Pop returns the RingBuffer's contents prioritizing higher priority and newer
+// logs first, or in weighted fair order if SetDrainWeights was called
+// Within a priority, entries are always popped in the reverse of the order their
+// writes acquired the RingBuffer's lock, which is also the order of their sequence
+// numbers, so concurrent writes at one priority pop in a well-defined order, except
+// for entries promoted by SetPromotionAge
+func (r *RingBuffer) Pop(priPrefix bool) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, err := r.popEntry()
+	if err != nil {
+		return "", err
+	}
+
+	if priPrefix {
+		return fmt.Sprintf("%s %s", PriorityString(e.p), string(e.data)), nil
+	}
+	return string(e.data), nil
+}
+
+// PopP behaves like Pop, but also returns the priority the entry was stored at. For
+// entries written with Write, that is the default priority at the time of the write
+func (r *RingBuffer) PopP() (string, LogPriority, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, err := r.popEntry()
+	if err != nil {
+		return "", 0, err
+	}
+	return string(e.data), e.p, nil
+}
+
+// PopWithRemaining behaves like Pop without a priority prefix, but also returns the
+// number of entries that Pop can still return afterwards, including sticky entries if
+// Pop returns them. The count is read under the same lock as the pop, so a drain loop
+// doesn't need a separate call to size its progress
+func (r *RingBuffer) PopWithRemaining() (s string, remaining int, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, err := r.popEntry()
+	if err != nil {
+		return "", 0, err
+	}
+	return string(e.data), r.remaining(), nil
+}
+
+// remaining returns the number of entries that Pop can return
+// The caller must hold r.lock
+func (r *RingBuffer) remaining() int {
+	if r.stickyPop {
+		return r.total + len(r.sticky)
+	}
+	return r.total
+}
+
+// PopEntry removes and returns the highest priority, newest entry along with the
+// priority and time it was written at
+func (r *RingBuffer) PopEntry() (Entry, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, err := r.popEntry()
+	if err != nil {
+		return Entry{}, err
+	}
+	return e.export(), nil
+}
+
+// PopMin behaves like PopEntry, but only returns entries with priority min or higher
+// It pops the entry Pop would return if the entries below min weren't stored, so
+// entries below min, such as ones promoted by SetPromotionAge, never hold it up. A
+// sticky entry with priority min or higher is returned once no ring entry qualifies
+// If no entry has priority min or higher, PopMin returns ErrBufferEmpty and leaves
+// the RingBuffer unchanged
+func (r *RingBuffer) PopMin(min LogPriority) (Entry, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, err := r.popFrom(r.searchPrios(int(min)))
+	if err == ErrBufferEmpty {
+		if j := r.stickyTop(); j >= 0 && !r.less(r.sticky[j].p, min) {
+			e, err = r.popSticky()
+		}
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return e.export(), nil
+}
+
+// popEntry removes and returns the entry Pop returns next, falling back to sticky
+// entries once the priority rings are empty
+// The caller must hold r.lock
+func (r *RingBuffer) popEntry() (*entry, error) {
+	e, err := r.popFrom(0)
+	if err == ErrBufferEmpty {
+		return r.popSticky()
+	}
+	return e, err
+}
+
+// popFrom removes and returns the oldest entry due for promotion, if any, or else the
+// newest entry of the priority chosen by drainPriority, considering only the
+// priorities in r.prios[from:]. It returns ErrBufferEmpty if none of them has entries
+// The caller must hold r.lock
+func (r *RingBuffer) popFrom(from int) (*entry, error) {
+	if pr, rg := r.promoted(from); pr != nil {
+		e := r.removeAt(pr, rg)
+		r.freeRing(int(e.p))
+		return e, nil
+	}
+
+	i, ok := r.drainPriority(from)
+	if !ok {
+		return nil, ErrBufferEmpty
+	}
+	pr := r.getRing(i)
+	if r.weights != nil {
+		r.drained[LogPriority(i)]++
+	}
+
+	// skip unused slots rather than treating the first one as the end of the ring
+	rg := pr.r.Prev()
+	for rg.Value == nil {
+		rg = rg.Prev()
+	}
+	pr.r = rg
+
+	e, ok := pr.r.Value.(*entry)
+	if !ok {
+		return nil, fmt.Errorf("pop type assertion failed")
+	}
+
+	pr.r.Value = nil
+	pr.n--
+	r.total--
+	r.size -= len(e.data)
+	r.updateHighP()
+	r.freeRing(i)
+
+	return e, nil
+}
+
+// freeRing releases the ring for priority i if it's outside of buf's range and has no
+// entries left, so popping entries of many distinct priorities doesn't grow r.sparse
+// and r.prios without bound. Rings in buf's range stay allocated for reuse
+// The caller must hold r.lock
+func (r *RingBuffer) freeRing(i int) {
+	if pr, ok := r.sparse[i]; !ok || pr.n > 0 {
+		return
+	}
+
+	delete(r.sparse, i)
+	for j := r.searchPrios(i); j < len(r.prios); j++ {
+		if r.prios[j] == i {
+			r.prios = append(r.prios[:j], r.prios[j+1:]...)
+			break
+		}
+	}
+}
+
+// drainPriority returns the priority in r.prios[from:] whose ring Pop takes its next
+// entry from, or false if none of their rings has entries. Without drain weights, it
+// is the highest priority with stored entries. With them, it is the highest priority
+// with stored entries that hasn't used up its weight in the current round; once every
+// such priority has, a new round starts
+// The caller must hold r.lock
+func (r *RingBuffer) drainPriority(from int) (int, bool) {
+	// highP is the highest priority with entries, so if it's below from, none is
+	if r.count(r.highP) == 0 || r.searchPrios(r.highP) < from {
+		return 0, false
+	}
+	if r.weights == nil {
+		return r.highP, true
+	}
+
+	for j := len(r.prios) - 1; j >= from; j-- {
+		i := r.prios[j]
+		if r.count(i) > 0 && r.drained[LogPriority(i)] < r.weight(LogPriority(i)) {
+			return i, true
+		}
+	}
+	clear(r.drained)
+	return r.highP, true
+}
+
+// weight returns the drain weight of priority p
+// The caller must hold r.lock
+func (r *RingBuffer) weight(p LogPriority) int {
+	if w := r.weights[p]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// SetDrainWeights switches Pop from strict priority order to weighted fair order, so
+// sustained high priority writes can't starve lower priorities. Pop works in rounds;
+// in each round, a priority with stored entries is popped up to its weight times,
+// with higher priorities going first. For example, the weights
+//
+//	map[LogPriority]int{Critical: 4, Major: 2, Minor: 1}
+//
+// pop 4 Critical entries, then 2 Major, then 1 Minor, before starting over. Priorities
+// without a positive weight have a weight of 1. Entries within a priority are still
+// popped newest first, and sticky entries are still popped last
+// ForEach and other non-consuming traversals keep strict priority order. Passing nil
+// or an empty map restores strict priority order, which is the default
+func (r *RingBuffer) SetDrainWeights(weights map[LogPriority]int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(weights) == 0 {
+		r.weights = nil
+		r.drained = nil
+		return
+	}
+
+	r.weights = make(map[LogPriority]int, len(weights))
+	for p, w := range weights {
+		r.weights[p] = w
+	}
+	r.drained = make(map[LogPriority]int)
+}
+
+// SetPromotionAge sets the age at which entries are promoted ahead of priority order
+// While any stored entry is at least age old, Pop returns the oldest stored entry,
+// whatever its priority, so low priority entries under sustained high priority load
+// are read before a global limit such as SetMaxEntries can evict them. Entries that
+// are younger are popped in the usual order. Promotion applies with or without drain
+// weights, and takes precedence over them. An age of 0, the default, disables it
+func (r *RingBuffer) SetPromotionAge(age time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.promoteAge = max(age, 0)
+}
+
+// promoted returns the ring and slot of the oldest entry stored with a priority in
+// r.prios[from:] if it's due for promotion, or nil
+// The caller must hold r.lock
+func (r *RingBuffer) promoted(from int) (*prioRing, *ring.Ring) {
+	if r.promoteAge == 0 {
+		return nil, nil
+	}
+
+	var oldest *prioRing
+	var oldestSlot *ring.Ring
+	for _, i := range r.prios[from:] {
+		pr := r.getRing(i)
+		if pr.n == 0 {
+			continue
+		}
+
+		// the oldest entry is the first stored one after the next write position
+		rg := pr.r
+		for rg.Value == nil {
+			rg = rg.Next()
+		}
+		if oldest == nil || olderThan(rg.Value.(*entry), oldestSlot.Value.(*entry)) {
+			oldest, oldestSlot = pr, rg
+		}
+	}
+	if oldest == nil || time.Since(oldestSlot.Value.(*entry).t) < r.promoteAge {
+		return nil, nil
+	}
+	return oldest, oldestSlot
+}
+
+// olderThan returns whether a was written before b, by write time and then sequence
+// number
+func olderThan(a, b *entry) bool {
+	if !a.t.Equal(b.t) {
+		return a.t.Before(b.t)
+	}
+	return a.seq < b.seq
+}
+
+// PopAll pops every entry from the RingBuffer in the same order as Pop and returns
+// them. The priority rings stay allocated so later writes reuse them
+func (r *RingBuffer) PopAll(priPrefix bool) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.popAll(priPrefix)
+}
+
+// DrainAll pops every entry from the RingBuffer like PopAll, without priority
+// prefixes, implementing Drainer. It returns an empty slice if nothing is buffered
+func (r *RingBuffer) DrainAll() []string {
+	return r.PopAll(false)
+}
+
+// PopIfBytes pops every entry from the RingBuffer like PopAll, without priority
+// prefixes, if the entries' total length, as reported by Bytes, is at least minBytes
+// Otherwise, it pops nothing and returns false. The check and the pops happen under
+// a single lock acquisition, so no write can slip in between
+func (r *RingBuffer) PopIfBytes(minBytes int) ([]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.size < minBytes {
+		return nil, false
+	}
+	return r.popAll(false), true
+}
+
+// popAll implements PopAll
+// The caller must hold r.lock
+func (r *RingBuffer) popAll(priPrefix bool) []string {
+	ret := make([]string, 0, r.total)
+	for {
+		e, err := r.popEntry()
+		if err != nil {
+			return ret
+		}
+
+		if priPrefix {
+			ret = append(ret, fmt.Sprintf("%s %s", PriorityString(e.p), string(e.data)))
+		} else {
+			ret = append(ret, string(e.data))
+		}
+	}
+}
+
+// Reset discards every entry in the RingBuffer, including sticky entries and any
+// pending line framing entry. The priority rings stay allocated so later writes reuse them
+func (r *RingBuffer) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, i := range r.prios {
+		pr := r.getRing(i)
+		for j := 0; j < pr.r.Len(); j++ {
+			pr.r.Value = nil
+			pr.r = pr.r.Next()
+		}
+		pr.n = 0
+	}
+	r.total = 0
+	r.size = 0
+	r.highP = 0
+	r.line = nil
+	r.sticky = nil
+	clear(r.drained)
+}
+
+// ForEach calls fn for each entry in the RingBuffer, in the same order Pop would
+// return them, without removing any. Sticky entries are visited last, whether or not
+// Pop returns them. Traversal stops early if fn returns false
+// fn is called while the RingBuffer is locked, so it must not call RingBuffer methods,
+// and it must not modify or retain data
+func (r *RingBuffer) ForEach(fn func(p LogPriority, data []byte) bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.each(func(e *entry) bool {
+		return fn(e.p, e.data)
+	})
+}
+
+// ForEachEntry calls fn for each entry in the RingBuffer, visiting them as ForEach
+// does, with the priority, write time, fields, and sequence number PopEntry would
+// return. Traversal stops early if fn returns false
+// As with ForEach, fn must not call RingBuffer methods
+func (r *RingBuffer) ForEachEntry(fn func(e Entry) bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.each(func(e *entry) bool {
+		return fn(e.export())
+	})
+}
+
+// ForEachPriority calls fn for each entry in priority p's ring, newest first, without
+// removing any. Only the ring is walked, so sticky entries aren't visited. Traversal
+// stops early if fn returns false
+// As with ForEach, fn must not call RingBuffer methods or modify or retain data
+func (r *RingBuffer) ForEachPriority(p LogPriority, fn func(data []byte) bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pr := r.getRing(int(p))
+	if pr == nil {
+		return
+	}
+	pr.walk(func(rg *ring.Ring) bool {
+		return fn(rg.Value.(*entry).data)
+	})
+}
+
+// CountFunc returns the number of entries in the RingBuffer for which match returns
+// true, visiting entries as ForEach does
+func (r *RingBuffer) CountFunc(match func(p LogPriority, data []byte) bool) int {
+	var n int
+	r.ForEach(func(p LogPriority, data []byte) bool {
+		if match(p, data) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// Tail returns up to n of the most recently written entries across all priorities,
+// including sticky entries, oldest first, without removing them. Recency is decided by
+// sequence number, so entries keep their write order even when written within the same
+// clock tick
+func (r *RingBuffer) Tail(n int) []Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if n <= 0 {
+		return nil
+	}
+
+	// each ring is walked newest first, so only its n newest entries can qualify
+	var candidates []*entry
+	for _, i := range r.prios {
+		taken := 0
+		r.getRing(i).walk(func(rg *ring.Ring) bool {
+			candidates = append(candidates, rg.Value.(*entry))
+			taken++
+			return taken < n
+		})
+	}
+	candidates = append(candidates, r.sticky[max(len(r.sticky)-n, 0):]...)
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].seq > candidates[j].seq
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	tail := make([]Entry, len(candidates))
+	for k, e := range candidates {
+		tail[len(tail)-1-k] = e.export()
+	}
+	return tail
+}
+
+// Each pops every entry from the RingBuffer, in Pop order, and calls fn with its
+// message and priority. Unlike ForEach, it consumes the entries
+// fn is called without the RingBuffer locked, so it may call RingBuffer methods.
+// Entries written by fn are drained by the same call
+func (r *RingBuffer) Each(fn func(s string, p LogPriority)) {
+	for {
+		e, err := r.PopEntry()
+		if err != nil {
+			return
+		}
+		fn(e.Message, e.Priority)
+	}
+}
+
+// each calls fn for each entry in Pop order, followed by the sticky entries, until fn
+// returns false
+// The caller must hold r.lock
+func (r *RingBuffer) each(fn func(e *entry) bool) {
+	for j := len(r.prios) - 1; j >= 0; j-- {
+		stopped := false
+		r.getRing(r.prios[j]).walk(func(rg *ring.Ring) bool {
+			stopped = !fn(rg.Value.(*entry))
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+
+	// newest first, then stable sorted so higher priorities come first
+	sticky := make([]*entry, len(r.sticky))
+	for k, e := range r.sticky {
+		sticky[len(sticky)-1-k] = e
+	}
+	sort.SliceStable(sticky, func(i, j int) bool {
+		return r.less(sticky[j].p, sticky[i].p)
+	})
+	for _, e := range sticky {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// DebugString returns a multi-line, human-readable dump of the RingBuffer's state
+// Each allocated priority ring is listed from highest to lowest priority with its
+// occupancy and its entries from newest to oldest. The RingBuffer is not modified
+func (r *RingBuffer) DebugString() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "RingBuffer priority=%s cap=%d entries=%d highP=%d\n",
+		PriorityString(r.p), r.bufCap, r.total, r.highP)
+
+	for j := len(r.prios) - 1; j >= 0; j-- {
+		i := r.prios[j]
+		pr := r.getRing(i)
+		fmt.Fprintf(&sb, "%s (%d): %d/%d\n", PriorityString(LogPriority(i)), i, pr.n, r.bufCap)
+		rg := pr.r
+		for j := 0; j < rg.Len(); j++ {
+			rg = rg.Prev()
+			if e, ok := rg.Value.(*entry); ok {
+				fmt.Fprintf(&sb, "  %q\n", e.data)
+			}
+		}
+	}
+	if len(r.line) > 0 {
+		fmt.Fprintf(&sb, "pending line: %q\n", r.line)
+	}
+
+	return sb.String()
+}
+
+// Validate checks the RingBuffer's internal invariants and returns an error describing
+// the first violation found, or nil. It checks that the allocated priorities are
+// sorted and indexed, that each ring's entry count matches its occupied slots, that
+// the entry and byte totals match the stored entries, that highP is the highest
+// priority with entries, and that sequence numbers increase from oldest to newest
+// within each ring. It's meant for tests and debugging, as it visits every slot
+func (r *RingBuffer) Validate() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var total, size int
+	for j, i := range r.prios {
+		if j > 0 && !r.less(LogPriority(r.prios[j-1]), LogPriority(i)) {
+			return fmt.Errorf("plog: priorities %d and %d are out of order", r.prios[j-1], i)
+		}
+		pr := r.getRing(i)
+		if pr == nil {
+			return fmt.Errorf("plog: priority %d has no ring", i)
+		}
+		if slots := pr.r.Len(); pr.slots != slots || slots > r.bufCap {
+			return fmt.Errorf("plog: priority %d ring has %d slots, recorded as %d with capacity %d", i, slots, pr.slots, r.bufCap)
+		}
+
+		// visit oldest first, starting at the next write position
+		var n int
+		var seq uint64
+		rg := pr.r
+		for k := 0; k < pr.slots; k++ {
+			if e, ok := rg.Value.(*entry); ok {
+				if e.p != LogPriority(i) {
+					return fmt.Errorf("plog: priority %d ring holds an entry with priority %d", i, e.p)
+				}
+				if n > 0 && e.seq <= seq {
+					return fmt.Errorf("plog: priority %d ring holds sequence number %d after %d", i, e.seq, seq)
+				}
+				if e.seq > r.seq {
+					return fmt.Errorf("plog: priority %d ring holds sequence number %d beyond %d", i, e.seq, r.seq)
+				}
+				n++
+				seq = e.seq
+				size += len(e.data)
+			}
+			rg = rg.Next()
+		}
+		if n != pr.n {
+			return fmt.Errorf("plog: priority %d ring holds %d entries, counted as %d", i, n, pr.n)
+		}
+		total += n
+	}
+	if allocated := len(r.sparse); allocated+countRings(r.buf) != len(r.prios) {
+		return fmt.Errorf("plog: %d rings allocated for %d priorities", allocated+countRings(r.buf), len(r.prios))
+	}
+
+	if total != r.total {
+		return fmt.Errorf("plog: %d entries stored, counted as %d", total, r.total)
+	}
+	if r.max > 0 && total > r.max {
+		return fmt.Errorf("plog: %d entries stored, beyond the limit of %d", total, r.max)
+	}
+	for _, e := range r.sticky {
+		size += len(e.data)
+	}
+	if size != r.size {
+		return fmt.Errorf("plog: %d bytes stored, counted as %d", size, r.size)
+	}
+
+	if total > 0 {
+		if r.count(r.highP) == 0 {
+			return fmt.Errorf("plog: highP %d has no entries", r.highP)
+		}
+		for j := len(r.prios) - 1; j >= 0 && r.prios[j] != r.highP; j-- {
+			if r.count(r.prios[j]) > 0 {
+				return fmt.Errorf("plog: highP %d is below priority %d, which has entries", r.highP, r.prios[j])
+			}
+		}
+	}
+	return nil
+}
+
+// countRings returns the number of allocated rings in buf
+func countRings(buf []*prioRing) int {
+	var n int
+	for _, pr := range buf {
+		if pr != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// PrioritySnapshot describes the ring of a single priority, as returned by Inspect
+type PrioritySnapshot struct {
+	Priority LogPriority
+	Capacity int // number of slots the ring can grow to
+	WritePos int // index in Slots of the next slot to be written
+	Slots    []SlotState
+}
+
+// SlotState describes a single slot of a priority ring
+type SlotState struct {
+	Occupied bool
+	Len      int // length of the entry in the slot, or 0 if it's empty
+}
+
+// Inspect returns a snapshot of each allocated priority ring, from highest to lowest
+// priority, for tools that render the RingBuffer's layout. Slots are numbered from a
+// fixed slot of each ring, so successive snapshots show the write position moving
+// around the ring. Rings of a RingBuffer created by NewGrowingRingBuffer, or shrunk
+// by Compact, have fewer slots than their capacity, and are renumbered when they
+// shrink. Sticky entries aren't part of any ring and aren't included
+func (r *RingBuffer) Inspect() []PrioritySnapshot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	snapshots := make([]PrioritySnapshot, 0, len(r.prios))
+	for j := len(r.prios) - 1; j >= 0; j-- {
+		i := r.prios[j]
+		pr := r.getRing(i)
+		snap := PrioritySnapshot{
+			Priority: LogPriority(i),
+			Capacity: r.bufCap,
+			Slots:    make([]SlotState, 0, pr.r.Len()),
+		}
+
+		rg := pr.origin
+		for k := 0; k < pr.r.Len(); k++ {
+			if rg == pr.r {
+				snap.WritePos = k
+			}
+			var state SlotState
+			if e, ok := rg.Value.(*entry); ok {
+				state = SlotState{Occupied: true, Len: len(e.data)}
+			}
+			snap.Slots = append(snap.Slots, state)
+			rg = rg.Next()
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots
+}
+
+// removeEntry deletes the newest entry with priority p whose data equals b
+func (r *RingBuffer) removeEntry(p LogPriority, b []byte) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.remove(p, b)
+}
+
+// remove deletes the newest entry with priority p whose data equals b, shifting older
+// entries forward so the stored entries stay contiguous. It reports whether an entry
+// was removed
+// The caller must hold r.lock
+func (r *RingBuffer) remove(p LogPriority, b []byte) bool {
+	pr := r.getRing(int(p))
+	if pr == nil {
+		return false
+	}
+
+	removed := false
+	pr.walk(func(rg *ring.Ring) bool {
+		if bytes.Equal(rg.Value.(*entry).data, b) {
+			r.removeAt(pr, rg)
+			removed = true
+		}
+		return !removed
+	})
+	return removed
+}
+
+// removeAt deletes and returns the entry in slot rg of pr, shifting older entries
+// forward so the stored entries stay contiguous
+// The caller must hold r.lock
+func (r *RingBuffer) removeAt(pr *prioRing, rg *ring.Ring) *entry {
+	e := rg.Value.(*entry)
+	for rg.Prev().Value != nil && rg.Prev() != pr.r.Prev() {
+		rg.Value = rg.Prev().Value
+		rg = rg.Prev()
+	}
+	rg.Value = nil
+
+	pr.n--
+	r.total--
+	r.size -= len(e.data)
+	r.updateHighP()
+
+	return e
+}
+
+// updateHighP lowers highP to the highest priority that still has entries
+// The caller must hold r.lock
+// Only allocated priorities are scanned, so the cost depends on the number of
+// priorities in use rather than their values
+func (r *RingBuffer) updateHighP() {
+	hi := sort.Search(len(r.prios), func(j int) bool {
+		return r.less(LogPriority(r.highP), LogPriority(r.prios[j]))
+	})
+	for j := hi - 1; j >= 0; j-- {
+		if r.count(r.prios[j]) > 0 {
+			r.highP = r.prios[j]
+			break
+		}
+	}
+}
+
+// Compact shrinks each priority ring to fit the entries it currently holds, so the
+// memory held by rings that filled up during a burst can be reclaimed by the garbage
+// collector. It also releases the interning pool and any unused sticky entry storage
+// Compact doesn't change the RingBuffer's capacity; a compacted ring grows again as
+// entries are written to it, up to the capacity
+func (r *RingBuffer) Compact() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, i := range r.prios {
+		r.shrink(r.getRing(i))
+	}
+	r.sticky = append([]*entry(nil), r.sticky...)
+	if r.intern != nil {
+		r.intern = make(map[string][]byte)
+	}
+}
+
+// shrink replaces the ring of pr with one that has a slot for each stored entry, or a
+// single slot if pr is empty
+// The caller must hold r.lock
+func (r *RingBuffer) shrink(pr *prioRing) {
+	slots := max(pr.n, 1)
+	if slots == pr.slots {
+		return
+	}
+
+	entries := make([]*entry, 0, pr.n)
+	pr.walk(func(rg *ring.Ring) bool {
+		entries = append(entries, rg.Value.(*entry))
+		return true
+	})
+
+	// store oldest first so the write position ends up after the newest entry
+	rg := ring.New(slots)
+	pr.origin = rg
+	for k := len(entries) - 1; k >= 0; k-- {
+		rg.Value = entries[k]
+		rg = rg.Next()
+	}
+	pr.r = rg
+	pr.slots = slots
+}
+
+// grow adds empty slots to pr, which must be full, between its newest and oldest
+// entries and moves the write position to the first of them. The number of slots is
+// doubled, up to the RingBuffer's capacity
+// The caller must hold r.lock
+func (r *RingBuffer) grow(pr *prioRing) {
+	k := min(pr.slots, r.bufCap-pr.slots)
+	newest := pr.r.Prev()
+	newest.Link(ring.New(k))
+	pr.r = newest.Next()
+	pr.slots += k
+}
+
+// SetMaxEntries limits the number of entries stored across all priorities to n
+// Once the limit is reached, each write that would store an additional entry first
+// evicts the oldest entry of the lowest buffered priority. Evicted entries are
+// written to the spill writer, if one is set
+// A limit of 0 removes the limit. Lowering the limit does not evict stored entries
+// until the next write
+func (r *RingBuffer) SetMaxEntries(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.max = n
+}
+
+// SetEvictionScore sets the function that chooses which entry to evict once the
+// limit set by SetMaxEntries is reached. Each stored entry is scored with its age,
+// and the entry with the lowest score is evicted, with ties going to the lowest
+// priority, oldest entry. Scoring visits every stored entry, so it makes evictions
+// cost time proportional to the number of entries. Sticky entries are never scored
+// To keep that cost down, the Entry passed to fn has no Message, and its Fields must
+// not be modified. If fn panics, the panic is recovered and recorded as with
+// SetPanicLogging, and the default eviction applies instead
+// Passing nil restores the default, which evicts the oldest entry of the lowest
+// buffered priority
+func (r *RingBuffer) SetEvictionScore(fn func(e Entry, age time.Duration) float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.score = fn
+}
+
+// evict removes the oldest entry of the lowest buffered priority, or the lowest
+// scoring entry if an eviction scorer is set
+// The caller must hold r.lock
+func (r *RingBuffer) evict() error {
+	if r.score != nil {
+		if evicted, err := r.evictScored(); evicted {
+			return err
+		}
+	}
+
+	var victim *prioRing
+	for _, i := range r.prios {
+		if pr := r.getRing(i); pr.n > 0 {
+			victim = pr
+			break
+		}
+	}
+	if victim == nil {
+		return nil
+	}
+
+	// the oldest entry is the first stored one after the next write position
+	rg := victim.r
+	for rg.Value == nil {
+		rg = rg.Next()
+	}
+	return r.overflowed(r.removeAt(victim, rg))
+}
+
+// evictScored removes the entry with the lowest score according to r.score. It
+// returns false if the scorer panicked, in which case nothing is removed
+// The caller must hold r.lock
+func (r *RingBuffer) evictScored() (bool, error) {
+	var victim *prioRing
+	var victimSlot *ring.Ring
+	var low float64
+	now := time.Now()
+	panicErr := safeCall(func() {
+		for _, i := range r.prios {
+			pr := r.getRing(i)
+
+			// visit oldest first, starting after the next write position
+			rg := pr.r
+			for k := 0; k < pr.r.Len(); k++ {
+				if e, ok := rg.Value.(*entry); ok {
+					view := Entry{Priority: e.p, Time: e.t, Fields: e.fields, Seq: e.seq}
+					if score := r.score(view, now.Sub(e.t)); victim == nil || score < low {
+						victim, victimSlot, low = pr, rg, score
+					}
+				}
+				rg = rg.Next()
+			}
+		}
+	})
+	if panicErr != nil {
+		r.recovered(panicErr)
+		return false, nil
+	}
+	if victim == nil {
+		return true, nil
+	}
+	return true, r.overflowed(r.removeAt(victim, victimSlot))
+}
+
+// overflowed records e, which was evicted by overflow, in the archive and writes it
+// to the spill writer, if either is set
+func (r *RingBuffer) overflowed(e *entry) error {
+	r.drop(e.p)
+	r.archiveEntry(e)
+	if r.spill != nil {
+		return r.spillEntry(e)
+	}
+	return nil
+}
+
+// drop counts an entry with priority p that was lost to overflow
+// The caller must hold r.lock
+func (r *RingBuffer) drop(p LogPriority) {
+	if r.dropped == nil {
+		r.dropped = make(map[LogPriority]int)
+	}
+	r.dropped[p]++
+}
+
+// TakeDropped returns the number of entries of each priority that were lost to
+// overflow, whether evicted, overwritten, or rejected, since the previous call, and
+// resets the counts. Priorities with no dropped entries are omitted
+func (r *RingBuffer) TakeDropped() map[LogPriority]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	dropped := r.dropped
+	r.dropped = nil
+	if dropped == nil {
+		return map[LogPriority]int{}
+	}
+	return dropped
+}
+
+// SetSpillWriter sets a writer that receives entries evicted from the RingBuffer by
+// overflow. When a write would overwrite a stored entry, the evicted entry is first
+// written to w with a priority prefix and a trailing newline. The spill write happens
+// while the RingBuffer is locked, so w should not block for long
+// Passing nil disables spilling
+func (r *RingBuffer) SetSpillWriter(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.spill = w
+}
+
+// SetLineFraming enables or disables line framing
+// While line framing is enabled, Write and WriteByte accumulate bytes into a pending
+// entry rather than storing each call as its own entry. The pending entry is written
+// at the default priority when a newline is written (the newline itself is not stored)
+// or when Commit is called. PWrite is not affected by line framing
+// Disabling line framing commits any pending entry
+func (r *RingBuffer) SetLineFraming(enabled bool) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	if !enabled {
+		r.commit()
+	}
+	r.framing = enabled
+}
+
+// Commit writes the pending line framing entry, if any, at the default priority
+func (r *RingBuffer) Commit() error {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	return r.commit()
+}
+
+// commit writes the pending line to the ring
+// The caller must hold r.lock
+func (r *RingBuffer) commit() error {
+	if len(r.line) == 0 {
+		return nil
+	}
+	err := r.pwrite(r.writePriority(), r.line)
+	r.line = nil
+	return err
+}
+
+// Write write a slice of bytes (p) into it's ring buffer
+// The entry is written at the default priority unless the RingBuffer was created
+// with WithWritePriority. If line framing is enabled, b is split into entries on newlines instead
+func (r *RingBuffer) Write(b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	if !r.framing {
+		return writeResult(b, r.pwrite(r.writePriority(), b))
+	}
+
+	for _, c := range b {
+		if err := r.frameByte(c); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// WriteByte writes a single byte, implementing io.ByteWriter
+// Without line framing enabled, each byte is stored as its own entry
+func (r *RingBuffer) WriteByte(c byte) error {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	if !r.framing {
+		return r.pwrite(r.writePriority(), []byte{c})
+	}
+
+	return r.frameByte(c)
+}
+
+// frameByte adds c to the pending line, committing it if c is a newline
+// The caller must hold r.lock
+func (r *RingBuffer) frameByte(c byte) error {
+	if c == '\n' {
+		return r.commit()
+	}
+	r.line = append(r.line, c)
+	return nil
+}
+
+// PWrite writes to the ring buffer with priority p
+// The RingBuffer stores a copy of b, so callers may reuse b once PWrite returns,
+// unless it was created with WithZeroCopy
+func (r *RingBuffer) PWrite(p LogPriority, b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	return writeResult(b, r.pwrite(p, b))
+}
+
+// PWriteBatch writes each of entries with priority p, like PWrite, while holding the
+// RingBuffer's lock once for the whole batch. It returns the number of entries stored
+// If an entry is rejected by the overflow policy, PWriteBatch stops and returns
+// ErrBufferFull; the entries after it are not written. Otherwise, it returns the first
+// error from the spill writer, if any, and every entry is stored
+func (r *RingBuffer) PWriteBatch(p LogPriority, entries [][]byte) (int, error) {
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	var n int
+	var firstErr error
+	for _, b := range entries {
+		err := r.pwrite(p, b)
+		if err == ErrBufferFull {
+			return n, err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		n++
+	}
+	return n, firstErr
+}
+
+// writeResult converts the result of pwrite into io.Writer return values
+func writeResult(b []byte, err error) (int, error) {
+	if err == ErrBufferFull {
+		return 0, err
+	}
+	return len(b), err
+}
+
+// pwrite stores b in the p priority ring
+// It returns ErrBufferFull if the write was rejected by the overflow policy. Any
+// other error comes from the spill writer, in which case b is still stored
+// The caller must hold r.lock
+func (r *RingBuffer) pwrite(p LogPriority, b []byte) error {
+	return r.insert(&entry{
+		data: r.own(b),
+		p:    p,
+		t:    time.Now(),
+		seq:  r.nextSeq(),
+	})
+}
+
+// nextSeq increments and returns the RingBuffer's sequence number
+// The caller must hold r.lock
+func (r *RingBuffer) nextSeq() uint64 {
+	r.seq++
+	return r.seq
+}
+
+// Seq returns the sequence number assigned to the most recent entry written to the
+// RingBuffer, or 0 if nothing has been written. Every stored entry, sticky or not, is
+// numbered from a single counter, so sequence numbers increase in write order
+// Entries rejected by the overflow policy still consume a sequence number
+func (r *RingBuffer) Seq() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.seq
+}
+
+// own returns the slice to store for b, which is a copy unless the RingBuffer was
+// created with WithZeroCopy
+func (r *RingBuffer) own(b []byte) []byte {
+	if r.intern != nil {
+		if data, ok := r.intern[string(b)]; ok {
+			return data
+		}
+	}
+
+	data := b
+	if !r.zeroCopy {
+		data = append([]byte(nil), b...)
+	}
+
+	if r.intern != nil {
+		if len(r.intern) >= internLimit {
+			clear(r.intern)
+		}
+		r.intern[string(data)] = data
+	}
+	return data
+}
+
+// SetInterning sets whether entries with identical bodies share a single stored copy
+// This reduces memory use when many entries repeat the same message, at the cost of a
+// map lookup per write. The interning pool holds up to internLimit distinct bodies
+// and is cleared when it fills, so bodies that stop repeating are eventually released
+// Entry data is never modified once stored, so sharing it is safe. With WithZeroCopy,
+// a written slice may be shared by later entries, so it must not be modified either
+// Disabling interning discards the pool
+func (r *RingBuffer) SetInterning(enabled bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case !enabled:
+		r.intern = nil
+	case r.intern == nil:
+		r.intern = make(map[string][]byte)
+	}
+}
+
+// insert stores e in the ring for its priority, applying the overflow policy
+// It returns the same errors as pwrite
+// The caller must hold r.lock
+func (r *RingBuffer) insert(e *entry) error {
+	p := e.p
+	i := int(p)
+	pr := r.getRing(i)
+	if r.policy == RejectNewest {
+		full := r.max > 0 && r.total >= r.max
+		if pr != nil && pr.n >= r.bufCap {
+			full = true
+		}
+		if full {
+			r.drop(p)
+			return ErrBufferFull
+		}
+	}
+
+	if pr == nil {
+		pr = r.allocRing(i)
+	}
+	if pr.r.Value != nil && pr.slots < r.bufCap {
+		r.grow(pr)
+	}
+
+	var err error
+	if pr.r.Value == nil {
+		for r.max > 0 && r.total >= r.max {
+			if evictErr := r.evict(); evictErr != nil {
+				err = evictErr
+			}
+		}
+		pr.n++
+		r.total++
+		if fn := r.fullFns[p]; fn != nil && pr.n == r.bufCap {
+			r.fullQueue = append(r.fullQueue, fn)
+		}
+	} else {
+		old := pr.r.Value.(*entry)
+		r.size -= len(old.data)
+		err = r.overflowed(old)
+	}
+	r.size += len(e.data)
+
+	pr.r.Value = e
+	pr.r = pr.r.Next()
+
+	if r.count(r.highP) == 0 || r.less(LogPriority(r.highP), p) {
+		r.highP = i
+	}
+	r.signal()
+
+	return err
+}
+
+// spillEntry writes an evicted entry to the spill writer
+func (r *RingBuffer) spillEntry(e *entry) error {
+	b := make([]byte, 0, len(e.data)+16)
+	b = append(b, PriorityString(e.p)...)
+	b = append(b, ' ')
+	b = entryLine(append(b, e.data...))
+
+	var err error
+	if panicErr := safeCall(func() { _, err = r.spill.Write(b) }); panicErr != nil {
+		r.recovered(panicErr)
+		err = panicErr
+	}
+	if err != nil {
+		return fmt.Errorf("spill evicted entry: %v", err)
+	}
+	return nil
 }
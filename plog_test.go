@@ -1,8 +1,18 @@
 package plog
 
 import (
+	"bytes"
+	"container/ring"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestLogger tests Append usage
@@ -10,117 +20,2236 @@ import (
 // one or two lines that call Buffer functions
 func TestLogger(t *testing.T) {
 	t.Run("concurrent append", testLoggerConcurrentAppend)
+	t.Run("empty append", testLoggerEmptyAppend)
+	t.Run("strict locking", testLoggerStrictLocking)
+	t.Run("append session", testLoggerAppendSession)
+	t.Run("close", testLoggerClose)
+	t.Run("close timeout", testLoggerCloseTimeout)
+	t.Run("close print", testLoggerClosePrint)
+	t.Run("concurrent distinct append", testLoggerConcurrentDistinctAppend)
+	t.Run("swap buffer", testLoggerSwapBuffer)
+	t.Run("nil buffer", testLoggerNilBuffer)
+	t.Run("pause", testLoggerPause)
+	t.Run("adaptive sampling", testLoggerAdaptiveSampling)
+	t.Run("adaptive sampling comparator", testLoggerAdaptiveSamplingComparator)
+	t.Run("err chain", testLoggerErrChain)
+	t.Run("print ctx", testLoggerPrintCtx)
+	t.Run("print batch", testLoggerPrintBatch)
+	t.Run("newline normalization", testLoggerNewlineNormalization)
+	t.Run("priority format", testLoggerPriorityFormat)
+	t.Run("import", testLoggerImport)
+	t.Run("count", testLoggerCount)
+	t.Run("write", testLoggerWrite)
+	t.Run("print sync", testLoggerPrintSync)
+	t.Run("clone", testLoggerClone)
+	t.Run("print def", testLoggerPrintDef)
+}
+
+// testLoggerPrintDef asserts that PrintfDef and PrintlnDef use the Buffer's default
+// priority, including after it changes
+func testLoggerPrintDef(t *testing.T) {
+	t.Run("printf", func(t *testing.T) {
+		rb := NewRingBuffer(Major, 3)
+		l := NewLogger(rb)
+		l.PrintfDef("major%d %s", 0, "disk")
+		rb.SetPriority(Critical)
+		l.PrintfDef("critical%d", 0)
+
+		popWithExpected("Critical critical0", rb, true, t)
+		popWithExpected("Major major0 disk", rb, true, t)
+	})
+	t.Run("println", func(t *testing.T) {
+		rb := NewRingBuffer(Major, 3)
+		l := NewLogger(rb)
+		l.PrintlnDef("major0")
+		rb.SetPriority(Trivial)
+		l.PrintlnDef("trivial0")
+
+		popWithExpected("Major major0\n", rb, true, t)
+		popWithExpected("Trivial trivial0\n", rb, true, t)
+	})
+}
+
+// syncBuffer is a Buffer that records Sync calls and fails them after n calls
+type syncBuffer struct {
+	*RingBuffer
+	n     int
+	syncs int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	if b.syncs > b.n {
+		return errors.New("nemo")
+	}
+	return nil
+}
+
+func testLoggerPrintSync(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	if err := NewLogger(rb).PrintSync(Minor, "minor0"); err != nil {
+		t.Logf("unexpected error: %v\n", err)
+		t.Fail()
+	}
+	popWithExpected("minor0", rb, false, t)
+
+	sb := &syncBuffer{RingBuffer: NewRingBuffer(Minor, 3), n: 1}
+	l := NewLogger(sb)
+	if err := l.PrintSync(Minor, "minor1"); err != nil || sb.syncs != 1 {
+		t.Logf("unexpected result %v after %d syncs\n", err, sb.syncs)
+		t.Fail()
+	}
+	if err := l.PrintSync(Minor, "minor2"); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testLoggerClone asserts that a clone shares the Logger's configuration but not its
+// entries, and that its Buffer keeps the original's capacity and overflow policy
+func testLoggerClone(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetOverflowPolicy(RejectNewest)
+	l := NewLogger(rb)
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+
+	c, err := l.Clone()
+	if err != nil {
+		t.Fatalf("unexpected clone error: %v\n", err)
+	}
+	crb, ok := c.GetBuffer().(*RingBuffer)
+	if !ok || crb == rb {
+		t.Fatalf("expected a new *RingBuffer, got %T\n", c.GetBuffer())
+	}
+	c.Print(Major, "major0")
+	c.Print(Major, "major1")
+	if _, err := crb.PWrite(Major, []byte("major2")); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+	flushWithExpected("Major: major1\nMajor: major0\n", c, t)
+	flushWithExpected("Minor: minor0\n", l, t)
+
+	fb, err := NewFileBuffer(filepath.Join(t.TempDir(), "plog"), Major, nil)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	defer fb.Close()
+	if _, err := NewLogger(fb).Clone(); err == nil {
+		t.Log("expected an error cloning a FileBuffer")
+		t.Fail()
+	}
+	if _, err := NewLogger(NewDedupBuffer(fb, time.Hour)).Clone(); err == nil {
+		t.Log("expected an error cloning a DedupBuffer over a FileBuffer")
+		t.Fail()
+	}
+
+	bb := NewBatchingBuffer(NewDedupBuffer(NewRingBuffer(Major, 2), time.Hour), 3, 0, 0)
+	bb.SetMaxHeld(5)
+	c, err = NewLogger(bb).Clone()
+	if err != nil {
+		t.Fatalf("unexpected clone error: %v\n", err)
+	}
+	cbb, ok := c.GetBuffer().(*BatchingBuffer)
+	if !ok || cbb == bb || cbb.count != 3 || cbb.maxHeld != 5 {
+		t.Fatalf("expected a new *BatchingBuffer with the same settings, got %T\n", c.GetBuffer())
+	}
+	if d, ok := cbb.Buffer.(*DedupBuffer); !ok || d.window != time.Hour {
+		t.Fatalf("expected a wrapped *DedupBuffer, got %T\n", cbb.Buffer)
+	}
+	if rb, ok := cbb.Buffer.(*DedupBuffer).Buffer.(*RingBuffer); !ok || rb.GetPriority() != Major || rb.bufCap != 2 {
+		t.Logf("expected a Major *RingBuffer of capacity 2, got %T\n", cbb.Buffer.(*DedupBuffer).Buffer)
+		t.Fail()
+	}
+}
+
+func testLoggerPriorityFormat(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetPriorityFormat(Critical, func(msg string) string { return "!!! " + msg })
+	l.SetPriorityFormat(Major, func(msg string) string { panic("nemo") })
+	l.Print(Critical, "critical0")
+	l.Print(Major, "major0")
+	l.Print(Minor, "minor0")
+
+	popWithExpected("!!! critical0", rb, false, t)
+	popWithExpected("[format panic: string]", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+
+	l.SetPriorityFormat(Critical, nil)
+	l.Print(Critical, "critical1")
+	popWithExpected("critical1", rb, false, t)
+}
+
+// testLoggerWrite asserts that writes to the Logger as an io.Writer go through its
+// write pipeline and fail once it's closed
+func testLoggerWrite(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetPriorityFormat(Minor, strings.ToUpper)
+
+	var w io.Writer = l
+	if n, err := fmt.Fprint(w, "minor0"); err != nil || n != len("minor0") {
+		t.Logf("unexpected write result %d, %v\n", n, err)
+		t.Fail()
+	}
+	popWithExpected("MINOR0", rb, false, t)
+
+	l.Close()
+	if n, err := w.Write([]byte("minor1")); err != ErrLoggerClosed || n != 0 {
+		t.Logf("expected 0, %v, got %d, %v\n", ErrLoggerClosed, n, err)
+		t.Fail()
+	}
+}
+
+// testLoggerCount asserts that concurrent Count calls return distinct counts and
+// print their entries in count order
+func testLoggerCount(t *testing.T) {
+	rb := NewRingBuffer(Minor, 10)
+	l := NewLogger(rb)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Count(Minor, "event")
+		}()
+	}
+	wg.Wait()
+
+	for n := 10; n > 0; n-- {
+		popWithExpected(fmt.Sprintf("event count: %d", n), rb, false, t)
+	}
+	if n := l.Count(Major, "other"); n != 1 {
+		t.Logf("expected count 1, got %d\n", n)
+		t.Fail()
+	}
+}
+
+func testLoggerImport(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	var out bytes.Buffer
+	l := NewLogger(rb)
+	l.SetOutput(&out)
+
+	if n, err := l.Import([]string{"minor0", "minor1", "minor2"}, Minor); err != nil || n != 3 {
+		t.Logf("expected 3 lines imported, got %d, %v\n", n, err)
+		t.Fail()
+	}
+	if expected := "Minor: minor0\nMinor: minor1\nMinor: minor2\n"; out.String() != expected {
+		t.Logf("%q != %q\n", out.String(), expected)
+		t.Fail()
+	}
+	popWithExpected("minor2", rb, false, t)
+	popWithExpected("minor1", rb, false, t)
+
+	rb.SetOverflowPolicy(RejectNewest)
+	if n, err := l.Import([]string{"major0", "major1", "major2"}, Major); err != ErrBufferFull || n != 2 {
+		t.Logf("expected 2, %v, got %d, %v\n", ErrBufferFull, n, err)
+		t.Fail()
+	}
+	popWithExpected("major1", rb, false, t)
+
+	l.Close()
+	if n, err := l.Import([]string{"minor3"}, Minor); err != ErrLoggerClosed || n != 0 {
+		t.Logf("expected 0, %v, got %d, %v\n", ErrLoggerClosed, n, err)
+		t.Fail()
+	}
+}
+
+func testLoggerNewlineNormalization(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Print(Minor, "minor0\r\n")
+	l.SetNewlineNormalization(true)
+	l.Print(Minor, "minor1\r\nminor2\rminor3\n")
+
+	popWithExpected("minor1\nminor2\nminor3\n", rb, false, t)
+	popWithExpected("minor0\r\n", rb, false, t)
+}
+
+func testLoggerPrintBatch(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	var out bytes.Buffer
+	l.SetOutput(&out)
+	if err := l.PrintBatch(Major, []string{"major0", "major1"}); err != nil {
+		t.Logf("unexpected error: %v\n", err)
+		t.Fail()
+	}
+
+	if out.String() != "Major: major0\nMajor: major1\n" {
+		t.Logf("unexpected output %q\n", out.String())
+		t.Fail()
+	}
+	popWithExpected("major1", rb, false, t)
+	popWithExpected("major0", rb, false, t)
+}
+
+func testLoggerErrChain(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	base := errors.New("nemo")
+	l.PrintErrChain(Critical, fmt.Errorf("find: %w", fmt.Errorf("search reef: %w", base)))
+	l.PrintErrChain(Critical, nil)
+
+	e, err := rb.PopEntry()
+	expected := map[string]string{
+		"err.0": "find: search reef: nemo",
+		"err.1": "search reef: nemo",
+		"err.2": "nemo",
+	}
+	if err != nil || e.Message != "find: search reef: nemo" || fmt.Sprint(e.Fields) != fmt.Sprint(expected) {
+		t.Logf("unexpected entry %+v, %v\n", e, err)
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// ctxKey is the type of context keys used in tests
+type ctxKey string
+
+func testLoggerPrintCtx(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.BindContextKey(ctxKey("trace"), "trace_id")
+	l.BindContextKey(ctxKey("user"), "user_id")
+
+	ctx := context.WithValue(context.Background(), ctxKey("trace"), "abc123")
+	l.PrintCtx(ctx, Major, "major0")
+	l.PrintCtx(context.Background(), Minor, "minor0")
+
+	e, err := rb.PopEntry()
+	if err != nil || e.Message != "major0" || fmt.Sprint(e.Fields) != "map[trace_id:abc123]" {
+		t.Logf("unexpected entry %+v, %v\n", e, err)
+		t.Fail()
+	}
+	e, err = rb.PopEntry()
+	if err != nil || e.Message != "minor0" || e.Fields != nil {
+		t.Logf("unexpected entry %+v, %v\n", e, err)
+		t.Fail()
+	}
+}
+
+func testLoggerPause(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Print(Major, "major0")
+
+	l.Pause()
+	l.Pause()
+	l.Print(Critical, "critical0")
+	l.PrintSticky(Critical, "critical1")
+	l.Resume()
+	l.Print(Minor, "minor0")
+
+	if d := l.Dropped(); d != 2 {
+		t.Logf("expected 2 dropped entries, got %d\n", d)
+		t.Fail()
+	}
+	popWithExpected("major0", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+}
+
+// testLoggerAdaptiveSampling asserts that entries at or above the threshold are all
+// kept while 1 in n below it are, and that the rest are counted as dropped
+func testLoggerAdaptiveSampling(t *testing.T) {
+	rb := NewRingBuffer(Minor, 10)
+	l := NewLogger(rb)
+	l.SetAdaptiveSampling(Major, 3)
+	for i := 0; i < 7; i++ {
+		l.Print(Minor, fmt.Sprint("minor", i))
+		l.Print(Critical, fmt.Sprint("critical", i))
+	}
+
+	if d := l.Dropped(); d != 4 {
+		t.Logf("expected 4 dropped entries, got %d\n", d)
+		t.Fail()
+	}
+	for i := 6; i >= 0; i-- {
+		popWithExpected(fmt.Sprint("critical", i), rb, false, t)
+	}
+	popWithExpected("minor6", rb, false, t)
+	popWithExpected("minor3", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+}
+
+// testLoggerAdaptiveSamplingComparator asserts that the sampling threshold follows the
+// Buffer's priority comparator, so that with an inverted ordering the numerically
+// larger priorities are the ones sampled
+func testLoggerAdaptiveSamplingComparator(t *testing.T) {
+	rb := NewRingBuffer(Minor, 10)
+	rb.SetPriorityComparator(func(a, b LogPriority) bool { return a > b })
+	l := NewLogger(rb)
+	l.SetAdaptiveSampling(Major, 3)
+	for i := 0; i < 4; i++ {
+		l.Print(Minor, fmt.Sprint("minor", i))
+		l.Print(Critical, fmt.Sprint("critical", i))
+	}
+
+	if d := l.Dropped(); d != 2 {
+		t.Logf("expected 2 dropped entries, got %d\n", d)
+		t.Fail()
+	}
+	for i := 3; i >= 0; i-- {
+		popWithExpected(fmt.Sprint("minor", i), rb, false, t)
+	}
+	popWithExpected("critical3", rb, false, t)
+	popWithExpected("critical0", rb, false, t)
+}
+
+// testLoggerNilBuffer asserts that NewLogger panics when passed a nil Buffer rather
+// than returning a Logger that panics on first use
+func testLoggerNilBuffer(t *testing.T) {
+	defer func() {
+		if v := recover(); v == nil {
+			t.Logf("expected NewLogger(nil) to panic\n")
+			t.Fail()
+		}
+	}()
+	NewLogger(nil)
+}
+
+// testLoggerSwapBuffer swaps buffers while other goroutines print and asserts that
+// every entry ends up in exactly one of the buffers
+func testLoggerSwapBuffer(t *testing.T) {
+	first := NewRingBuffer(Minor, 100)
+	second := NewRingBuffer(Minor, 100)
+	l := NewLogger(first)
+	l.Print(Major, "nemo")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Print(Major, fmt.Sprint(i))
+		}(i)
+	}
+	old, err := l.SwapBuffer(second)
+	wg.Wait()
+
+	if err != nil || old != Buffer(first) {
+		t.Logf("expected SwapBuffer to return the previous buffer\n")
+		t.Fail()
+	}
+	if l.GetBuffer() != Buffer(second) {
+		t.Logf("expected GetBuffer to return the new buffer\n")
+		t.Fail()
+	}
+
+	seen := make(map[string]bool)
+	for _, rb := range []*RingBuffer{first, second} {
+		for {
+			s, err := rb.Pop(false)
+			if err != nil {
+				break
+			}
+			seen[s] = true
+		}
+	}
+	if len(seen) != 11 || !seen["nemo"] {
+		t.Logf("expected 11 entries including nemo, got %v\n", seen)
+		t.Fail()
+	}
+
+	// a failed swap keeps the current buffer and all of its entries
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+	l.Print(Minor, "minor1")
+	if _, err := l.SwapBuffer(NewSliceBuffer(Minor, 1)); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+	if l.GetBuffer() != Buffer(second) || second.Len() != 3 {
+		t.Logf("expected the current buffer to keep 3 entries, got %d\n", second.Len())
+		t.Fail()
+	}
 }
 
 func testLoggerConcurrentAppend(t *testing.T) {
 	rb := NewRingBuffer(Minor, 3)
-	l := NewLogger(rb)
+	l := NewLogger(rb)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup) {
+			defer wg.Done()
+			l.Lock()
+			defer l.Unlock()
+			l.Append("n")
+			l.Append("e")
+			l.Append("m")
+			l.Append("o")
+			l.AppendDone(Major)
+		}(&wg)
+	}
+	wg.Wait()
+
+	popWithExpected("nemo", rb, false, t)
+	popWithExpected("nemo", rb, false, t)
+	popWithExpected("nemo", rb, false, t)
+}
+
+func testLoggerEmptyAppend(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.AppendDone(Major)
+	if entries, _, _ := rb.MemStats(); entries != 0 {
+		t.Logf("expected no entries, got %d\n", entries)
+		t.Fail()
+	}
+
+	l.SetEmptyAppends(true)
+	l.AppendDone(Major)
+	popWithExpected("", rb, false, t)
+}
+
+// testLoggerStrictLocking asserts that Append and AppendDone panic without the lock
+// only once strict locking is enabled
+func testLoggerStrictLocking(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.Append("minor0")
+	l.AppendDone(Minor)
+	popWithExpected("minor0", rb, false, t)
+
+	l.SetStrictLocking(true)
+	for name, fn := range map[string]func(){
+		"Append":     func() { l.Append("minor1") },
+		"AppendDone": func() { l.AppendDone(Minor) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Logf("expected %s to panic without the lock\n", name)
+					t.Fail()
+				}
+			}()
+			fn()
+		}()
+	}
+
+	l.Lock()
+	l.Append("minor2")
+	l.AppendDone(Minor)
+	l.Unlock()
+	popWithExpected("minor2", rb, false, t)
+}
+
+// testLoggerConcurrentDistinctAppend has each goroutine append a unique string and
+// asserts that every string is popped intact, which catches entries that alias the
+// Logger's append buffer
+func testLoggerConcurrentDistinctAppend(t *testing.T) {
+	rb := NewRingBuffer(Minor, 10)
+	l := NewLogger(rb)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Lock()
+			defer l.Unlock()
+			l.Append("nemo")
+			l.Append(fmt.Sprint(i))
+			l.AppendDone(Major)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		s, err := rb.Pop(false)
+		if err != nil {
+			t.Logf("unexpected pop error: %v\n", err)
+			t.Fail()
+		}
+		seen[s] = true
+	}
+	for i := 0; i < 10; i++ {
+		if !seen[fmt.Sprintf("nemo%d", i)] {
+			t.Logf("missing entry nemo%d, got %v\n", i, seen)
+			t.Fail()
+		}
+	}
+}
+
+// testLoggerAppendSession assembles distinct entries in parallel sessions and asserts
+// that each is committed intact, and that aborted and finished sessions write nothing
+func testLoggerAppendSession(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	l := NewLogger(rb)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok := l.BeginAppend()
+			l.AppendTo(tok, "nemo")
+			l.AppendTo(tok, fmt.Sprint(i))
+			if err := l.CommitAppend(tok, Major); err != nil {
+				t.Logf("unexpected commit error: %v\n", err)
+				t.Fail()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	aborted := l.BeginAppend()
+	l.AppendTo(aborted, "dory")
+	l.AbortAppend(aborted)
+	if err := l.CommitAppend(aborted, Major); err != ErrSessionDone {
+		t.Logf("expected %v, got %v\n", ErrSessionDone, err)
+		t.Fail()
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		s, err := rb.Pop(false)
+		if err != nil {
+			t.Logf("unexpected pop error: %v\n", err)
+			t.Fail()
+			continue
+		}
+		seen[s] = true
+	}
+	for i := 0; i < 5; i++ {
+		if !seen[fmt.Sprintf("nemo%d", i)] {
+			t.Logf("missing entry nemo%d\n", i)
+			t.Fail()
+		}
+	}
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testLoggerClose asserts that Close waits for an open session to commit, and that
+// commits fail with ErrLoggerClosed once Close has returned
+func testLoggerClose(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+
+	tok := l.BeginAppend()
+	l.AppendTo(tok, "nemo")
+	closeErr := make(chan error)
+	go func() {
+		closeErr <- l.Close()
+	}()
+
+	// sessions started once Close is waiting are rejected
+	for probe := l.BeginAppend(); !probe.closed; probe = l.BeginAppend() {
+		l.AbortAppend(probe)
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.CommitAppend(tok, Major); err != nil {
+		t.Logf("unexpected commit error: %v\n", err)
+		t.Fail()
+	}
+	if err := <-closeErr; err != nil {
+		t.Logf("unexpected close error: %v\n", err)
+		t.Fail()
+	}
+	popWithExpected("nemo", rb, false, t)
+
+	late := l.BeginAppend()
+	l.AppendTo(late, "dory")
+	if err := l.CommitAppend(late, Major); err != ErrLoggerClosed {
+		t.Logf("expected %v, got %v\n", ErrLoggerClosed, err)
+		t.Fail()
+	}
+	if err := l.Close(); err != nil {
+		t.Logf("unexpected error closing twice: %v\n", err)
+		t.Fail()
+	}
+}
+
+// testLoggerClosePrint closes the Logger while other goroutines print and asserts that
+// nothing panics and every print after Close is dropped and counted
+func testLoggerClosePrint(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1000)
+	l := NewLogger(rb)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Print(Minor, "minor")
+				l.PrintBatch(Major, []string{"major"})
+			}
+		}()
+	}
+	if l.Closed() {
+		t.Log("Logger should not be closed yet")
+		t.Fail()
+	}
+	l.Close()
+	wg.Wait()
+
+	if !l.Closed() {
+		t.Log("Logger should be closed")
+		t.Fail()
+	}
+	if err := l.PrintSync(Minor, "late"); err != ErrLoggerClosed {
+		t.Logf("expected %v, got %v\n", ErrLoggerClosed, err)
+		t.Fail()
+	}
+	entries, _, _ := rb.MemStats()
+	if stored := int64(entries) + l.Dropped(); stored != 801 {
+		t.Logf("expected 801 entries stored or dropped, got %d\n", stored)
+		t.Fail()
+	}
+}
+
+// testLoggerCloseTimeout asserts that Close gives up on sessions that stay open past
+// the timeout and that their commits write nothing
+func testLoggerCloseTimeout(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	l := NewLogger(rb)
+	l.SetCloseTimeout(10 * time.Millisecond)
+
+	tok := l.BeginAppend()
+	l.AppendTo(tok, "nemo")
+	if err := l.Close(); err == nil {
+		t.Log("expected error closing with an open session")
+		t.Fail()
+	}
+	if err := l.CommitAppend(tok, Major); err != ErrLoggerClosed {
+		t.Logf("expected %v, got %v\n", ErrLoggerClosed, err)
+		t.Fail()
+	}
+	if err := l.CommitAppend(tok, Major); err != ErrSessionDone {
+		t.Logf("expected %v, got %v\n", ErrSessionDone, err)
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// TestTransfer moves entries between RingBuffers of different capacities and asserts
+// that priority and pop order are preserved
+func TestTransfer(t *testing.T) {
+	src := NewRingBuffer(Minor, 3)
+	src.Write([]byte("minor0"))
+	src.PWrite(Critical, []byte("critical0"))
+	src.Write([]byte("minor1"))
+	dst := NewRingBuffer(Trivial, 5)
+
+	n, err := Transfer(dst, src)
+	if n != 3 || err != nil {
+		t.Logf("expected 3, <nil>, got %d, %v\n", n, err)
+		t.Fail()
+	}
+	if _, err := src.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+	popWithExpected("Critical critical0", dst, true, t)
+	popWithExpected("Minor minor1", dst, true, t)
+	popWithExpected("Minor minor0", dst, true, t)
+
+	// write times, fields, and sticky entries are kept
+	src.PWriteFields(Major, []byte("major0"), map[string]string{"user": "nemo"})
+	src.PWriteSticky(Critical, []byte("sticky0"))
+	backdate(src, Major, time.Hour)
+	if n, err := Transfer(dst, src); n != 2 || err != nil {
+		t.Logf("expected 2, <nil>, got %d, %v\n", n, err)
+		t.Fail()
+	}
+	e, err := dst.PopEntry()
+	if err != nil || e.Fields["user"] != "nemo" || time.Since(e.Time) < time.Hour {
+		t.Logf("err: %v || metadata not kept: %+v\n", err, e)
+		t.Fail()
+	}
+	if n, _, _ := dst.MemStats(); n != 1 {
+		t.Logf("expected the sticky entry in dst, got %d entries\n", n)
+		t.Fail()
+	}
+
+	// entries that weren't written are put back in src
+	src.Write([]byte("minor2"))
+	src.PWrite(Critical, []byte("critical1"))
+	src.Write([]byte("minor3"))
+	small := NewSliceBuffer(Minor, 2)
+	if n, err := Transfer(small, src); n != 2 || err != ErrBufferFull {
+		t.Logf("expected 2, %v, got %d, %v\n", ErrBufferFull, n, err)
+		t.Fail()
+	}
+	popWithExpected("critical1", src, false, t)
+	if n := small.Len(); n != 2 {
+		t.Logf("expected 2 entries in dst, got %d\n", n)
+		t.Fail()
+	}
+}
+
+// TestRingBuffer runs a variety of subtests covering RingBuffer usage
+func TestRingBuffer(t *testing.T) {
+	t.Run("get priority", testRingBufferGetPriority)
+	t.Run("set priority", testRingBufferSetPriority)
+	t.Run("write", testRingBufferWrite)
+	t.Run("pwrite", testRingBufferPWrite)
+	t.Run("pop", testRingBufferPop)
+	t.Run("concurrent pop", testRingBufferConcurrentPop)
+	t.Run("overflow", testRingBufferOverflow)
+	t.Run("available", testRingBufferAvailable)
+	t.Run("len", testRingBufferLen)
+	t.Run("line framing", testRingBufferLineFraming)
+	t.Run("spill", testRingBufferSpill)
+	t.Run("priority full", testRingBufferPriorityFull)
+	t.Run("has priority", testRingBufferHasPriority)
+	t.Run("max entries", testRingBufferMaxEntries)
+	t.Run("eviction score", testRingBufferEvictionScore)
+	t.Run("pop all", testRingBufferPopAll)
+	t.Run("drain all", testRingBufferDrainAll)
+	t.Run("pop if bytes", testRingBufferPopIfBytes)
+	t.Run("reset", testRingBufferReset)
+	t.Run("debug string", testRingBufferDebugString)
+	t.Run("validate", testRingBufferValidate)
+	t.Run("reject newest", testRingBufferRejectNewest)
+	t.Run("write priority", testRingBufferWritePriority)
+	t.Run("pop entry", testRingBufferPopEntry)
+	t.Run("sparse priority", testRingBufferSparsePriority)
+	t.Run("huge priority", testRingBufferHugePriority)
+	t.Run("mem stats", testRingBufferMemStats)
+	t.Run("inspect", testRingBufferInspect)
+	t.Run("for each priority", testRingBufferForEachPriority)
+	t.Run("pop with remaining", testRingBufferPopWithRemaining)
+	t.Run("sticky", testRingBufferSticky)
+	t.Run("priority comparator", testRingBufferPriorityComparator)
+	t.Run("pop min", testRingBufferPopMin)
+	t.Run("for each", testRingBufferForEach)
+	t.Run("count func", testRingBufferCountFunc)
+	t.Run("bytes", testRingBufferBytes)
+	t.Run("partial fill", testRingBufferPartialFill)
+	t.Run("gap", testRingBufferGap)
+	t.Run("zero copy", testRingBufferZeroCopy)
+	t.Run("each", testRingBufferEach)
+	t.Run("pop slot", testRingBufferPopSlot)
+	t.Run("seq", testRingBufferSeq)
+	t.Run("seq contention", testRingBufferSeqContention)
+	t.Run("interning", testRingBufferInterning)
+	t.Run("drain weights", testRingBufferDrainWeights)
+	t.Run("promotion age", testRingBufferPromotionAge)
+	t.Run("try pop wait", testRingBufferTryPopWait)
+	t.Run("compact", testRingBufferCompact)
+	t.Run("pwrite batch", testRingBufferPWriteBatch)
+	t.Run("growing", testRingBufferGrowing)
+	t.Run("pop priority", testRingBufferPopP)
+	t.Run("tail", testRingBufferTail)
+}
+
+func testRingBufferGetPriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	p := rb.GetPriority()
+	if p != Minor {
+		t.Logf("expected %v, got %v\n", Minor, p)
+		t.Fail()
+	}
+}
+
+func testRingBufferSetPriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetPriority(Major)
+	p := rb.GetPriority()
+	if p != Major {
+		t.Logf("expected %v, got %v\n", Major, p)
+		t.Fail()
+	}
+}
+
+func testRingBufferWrite(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("nemo"))
+	popWithExpected("nemo", rb, false, t)
+}
+
+func testRingBufferPWrite(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(Major, []byte("nemo"))
+	popWithExpected("nemo", rb, false, t)
+}
+
+// testRingBufferPop inserts some strings in random order and asserts that they are
+// popped in the correct order
+func testRingBufferPop(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(Major, []byte("major0"))
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Trivial, []byte("trivial0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWrite(Major, []byte("major1"))
+	rb.Write([]byte("minor1"))
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWrite(Trivial, []byte("trivial1"))
+
+	popWithExpected("critical1", rb, false, t)
+	popWithExpected("critical0", rb, false, t)
+	popWithExpected("major1", rb, false, t)
+	popWithExpected("major0", rb, false, t)
+	popWithExpected("minor1", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+	popWithExpected("trivial1", rb, false, t)
+	popWithExpected("trivial0", rb, false, t)
+}
+
+// testRingBufferOverflow asserts that RingBuffer displays proper overflow behavior
+// for a ring buffer that has had more entries than its capacity inserted
+func testRingBufferOverflow(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.Write([]byte("0"))
+	rb.Write([]byte("1"))
+	rb.Write([]byte("2"))
+	rb.Write([]byte("3"))
+	rb.Write([]byte("4"))
+	rb.Write([]byte("5"))
+	rb.Write([]byte("6"))
+
+	popWithExpected("6", rb, false, t)
+	popWithExpected("5", rb, false, t)
+	popWithExpected("4", rb, false, t)
+	popWithExpected("3", rb, false, t)
+	popWithExpected("2", rb, false, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testRingBufferAvailable asserts that Available tracks the remaining slots at the
+// default priority through writes, overflow, and pops, and is capped by SetMaxEntries
+func testRingBufferAvailable(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	availableWithExpected(3, rb, t)
+
+	rb.Write([]byte("0"))
+	rb.PWrite(Major, []byte("major0"))
+	availableWithExpected(2, rb, t)
+
+	rb.Write([]byte("1"))
+	rb.Write([]byte("2"))
+	rb.Write([]byte("3"))
+	availableWithExpected(0, rb, t)
+
+	rb.Pop(false) // major0
+	rb.Pop(false) // 3
+	availableWithExpected(1, rb, t)
+
+	rb.PWrite(Major, []byte("major1"))
+	rb.SetMaxEntries(10)
+	availableWithExpected(1, rb, t)
+	rb.SetMaxEntries(3)
+	availableWithExpected(0, rb, t)
+	rb.SetMaxEntries(2)
+	availableWithExpected(0, rb, t)
+}
+
+func availableWithExpected(expected int, rb *RingBuffer, t *testing.T) {
+	if n := rb.Available(); n != expected {
+		t.Logf("expected %d available, got %d\n", expected, n)
+		t.Fail()
+	}
+}
+
+// testRingBufferLineFraming writes partial lines and single bytes and asserts that
+// entries are only stored once a newline is written or Commit is called
+func testRingBufferLineFraming(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.SetLineFraming(true)
+
+	rb.Write([]byte("ne"))
+	rb.WriteByte('m')
+	rb.Write([]byte("o\ndo"))
+	rb.WriteByte('r')
+	availableWithExpected(4, rb, t)
+
+	rb.Write([]byte("y"))
+	rb.Commit()
+	rb.Write([]byte("marlin"))
+	rb.SetLineFraming(false)
+	rb.WriteByte('!')
+
+	popWithExpected("!", rb, false, t)
+	popWithExpected("marlin", rb, false, t)
+	popWithExpected("dory", rb, false, t)
+	popWithExpected("nemo", rb, false, t)
+}
+
+// testRingBufferPriorityFull asserts that the OnPriorityFull callback runs once each
+// time its priority's ring fills, and that it runs without the RingBuffer's lock held
+func testRingBufferPriorityFull(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	var calls int
+	rb.OnPriorityFull(Critical, func() {
+		calls++
+		rb.Bytes()
+	})
+
+	callsWithExpected := func(expected int) {
+		if calls != expected {
+			t.Logf("expected %d callback calls, got %d\n", expected, calls)
+			t.Fail()
+		}
+	}
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor1"))
+	callsWithExpected(0)
+
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWrite(Critical, []byte("critical2"))
+	callsWithExpected(1)
+
+	rb.Pop(false)
+	rb.PWrite(Critical, []byte("critical3"))
+	callsWithExpected(2)
+
+	rb.OnPriorityFull(Critical, nil)
+	rb.Pop(false)
+	rb.PWrite(Critical, []byte("critical4"))
+	callsWithExpected(2)
+}
+
+// testRingBufferSpill asserts that entries evicted by overflow are written to the
+// spill writer in eviction order
+func testRingBufferSpill(t *testing.T) {
+	var spill bytes.Buffer
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetSpillWriter(&spill)
+
+	rb.Write([]byte("0"))
+	rb.Write([]byte("1"))
+	rb.Write([]byte("2"))
+	rb.PWrite(Major, []byte("major0\n"))
+	rb.Write([]byte("3"))
+
+	expected := "Minor 0\nMinor 1\n"
+	if spill.String() != expected {
+		t.Logf("%q != %q\n", spill.String(), expected)
+		t.Fail()
+	}
+	popWithExpected("major0\n", rb, false, t)
+	popWithExpected("3", rb, false, t)
+	popWithExpected("2", rb, false, t)
+}
+
+func testRingBufferHasPriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Major, []byte("major0"))
+
+	if !rb.HasPriority(Major) || rb.HasPriority(Critical) {
+		t.Log("expected Major and not Critical")
+		t.Fail()
+	}
+	if !rb.HasAtLeast(Trivial) || !rb.HasAtLeast(Major) || rb.HasAtLeast(Critical) {
+		t.Log("expected at least Major and not at least Critical")
+		t.Fail()
+	}
+
+	rb.Pop(false)
+	if rb.HasPriority(Major) || rb.HasAtLeast(Major) {
+		t.Log("expected no Major after pop")
+		t.Fail()
+	}
+}
+
+// testRingBufferMaxEntries asserts that the global entry limit evicts the oldest
+// entries of the lowest priority first
+func testRingBufferMaxEntries(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetMaxEntries(4)
+
+	rb.PWrite(Trivial, []byte("trivial0"))
+	rb.PWrite(Trivial, []byte("trivial1"))
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWrite(Major, []byte("major0"))
+	rb.PWrite(Major, []byte("major1"))
+	rb.PWrite(Critical, []byte("critical1"))
+
+	popWithExpected("critical1", rb, false, t)
+	popWithExpected("critical0", rb, false, t)
+	popWithExpected("major1", rb, false, t)
+	popWithExpected("major0", rb, false, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+// testRingBufferEvictionScore asserts that the scorer picks the evicted entry, here
+// evicting the oldest entry regardless of priority and then the middle one of a ring
+func testRingBufferEvictionScore(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.SetMaxEntries(2)
+	rb.SetEvictionScore(func(e Entry, age time.Duration) float64 {
+		if age < 0 {
+			t.Logf("negative age %v\n", age)
+			t.Fail()
+		}
+		return -age.Seconds()
+	})
+
+	rb.PWrite(Critical, []byte("critical0"))
+	time.Sleep(time.Millisecond)
+	rb.Write([]byte("minor0"))
+	time.Sleep(time.Millisecond)
+	rb.Write([]byte("minor1"))
+
+	rb.SetMaxEntries(3)
+	rb.SetEvictionScore(func(e Entry, age time.Duration) float64 {
+		if e.Seq == 3 { // minor1
+			return -1
+		}
+		return 0
+	})
+	rb.Write([]byte("minor2"))
+	rb.Write([]byte("minor3"))
+
+	popWithExpected("minor3", rb, false, t)
+	popWithExpected("minor2", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testRingBufferPopAll(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor1"))
+
+	expected := []string{"Critical critical0", "Minor minor1", "Minor minor0"}
+	all := rb.PopAll(true)
+	if fmt.Sprint(all) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", all, expected)
+		t.Fail()
+	}
+	if len(rb.PopAll(false)) != 0 {
+		t.Log("expected empty buffer")
+		t.Fail()
+	}
+
+	rb.Write([]byte("minor2"))
+	popWithExpected("minor2", rb, false, t)
+}
+
+func testRingBufferDrainAll(t *testing.T) {
+	var b Buffer = NewRingBuffer(Minor, 3)
+	d, ok := b.(Drainer)
+	if !ok {
+		t.Fatal("RingBuffer should implement Drainer")
+	}
+	if entries := d.DrainAll(); entries == nil || len(entries) != 0 {
+		t.Logf("expected an empty slice, got %#v\n", entries)
+		t.Fail()
+	}
+
+	b.Write([]byte("minor0"))
+	b.PWrite(Critical, []byte("critical0"))
+	b.Write([]byte("minor1"))
+	expected := []string{"critical0", "minor1", "minor0"}
+	if entries := d.DrainAll(); fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", entries, expected)
+		t.Fail()
+	}
+	if n := b.Len(); n != 0 {
+		t.Logf("expected an empty buffer, got %d entries\n", n)
+		t.Fail()
+	}
+}
+
+func testRingBufferPopIfBytes(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+
+	if entries, ok := rb.PopIfBytes(16); ok || entries != nil || rb.Bytes() != 15 {
+		t.Logf("expected nothing popped, got %q, %t with %d bytes left\n", entries, ok, rb.Bytes())
+		t.Fail()
+	}
+	entries, ok := rb.PopIfBytes(15)
+	if !ok || strings.Join(entries, ",") != "critical0,minor0" || rb.Bytes() != 0 {
+		t.Logf("unexpected result %q, %t with %d bytes left\n", entries, ok, rb.Bytes())
+		t.Fail()
+	}
+}
+
+func testRingBufferReset(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Reset()
+
+	availableWithExpected(3, rb, t)
+	if _, err := rb.Pop(false); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+
+	rb.Write([]byte("minor1"))
+	popWithExpected("minor1", rb, false, t)
+}
+
+func testRingBufferDebugString(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor1"))
+
+	expected := `RingBuffer priority=Minor cap=3 entries=3 highP=3
+Critical (3): 1/3
+  "critical0"
+Minor (1): 2/3
+  "minor1"
+  "minor0"
+`
+	if s := rb.DebugString(); s != expected {
+		t.Logf("%q != %q\n", s, expected)
+		t.Fail()
+	}
+	popWithExpected("critical0", rb, false, t)
+}
+
+func testRingBufferRejectNewest(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetOverflowPolicy(RejectNewest)
+	rb.SetMaxEntries(3)
+
+	rb.Write([]byte("0"))
+	rb.Write([]byte("1"))
+	if n, err := rb.Write([]byte("2")); n != 0 || err != ErrBufferFull {
+		t.Logf("expected 0, %v, got %d, %v\n", ErrBufferFull, n, err)
+		t.Fail()
+	}
+	rb.PWrite(Major, []byte("major0"))
+	if _, err := rb.PWrite(Major, []byte("major1")); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+
+	popWithExpected("major0", rb, false, t)
+	popWithExpected("1", rb, false, t)
+	popWithExpected("0", rb, false, t)
+}
+
+func testRingBufferWritePriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1, WithWritePriority(Critical))
+	rb.SetOverflowPolicy(RejectNewest)
+
+	rb.Write([]byte("critical0"))
+	if _, err := rb.Write([]byte("critical1")); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+	popWithExpected("Critical critical0", rb, true, t)
+}
+
+func testRingBufferPopEntry(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	before := time.Now()
+	rb.PWrite(Major, []byte("nemo"))
+
+	e, err := rb.PopEntry()
+	if err != nil || e.Message != "nemo" || e.Priority != Major || e.Time.Before(before) {
+		t.Logf("unexpected entry %+v, err: %v\n", e, err)
+		t.Fail()
+	}
+	if _, err := rb.PopEntry(); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferSparsePriority mixes priorities stored in the dense ring slice with
+// ones stored in the sparse fallback map, and asserts that sparse rings are released
+// once popping empties them
+func testRingBufferSparsePriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(LogPriority(1000), []byte("sparse0"))
+	rb.Write([]byte("minor0"))
+	rb.PWrite(LogPriority(64), []byte("sparse1"))
+	rb.PWrite(Critical, []byte("critical0"))
+
+	popWithExpected("sparse0", rb, false, t)
+	popWithExpected("sparse1", rb, false, t)
+	popWithExpected("critical0", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+
+	for i := 0; i < 1000; i++ {
+		rb.PWrite(LogPriority(1000+i), []byte(fmt.Sprint("sparse", i)))
+		rb.PWrite(LogPriority(-1-i), []byte(fmt.Sprint("negative", i)))
+		popWithExpected(fmt.Sprint("sparse", i), rb, false, t)
+	}
+	for i := 0; i < 1000; i++ {
+		popWithExpected(fmt.Sprint("negative", i), rb, false, t)
+	}
+	if len(rb.sparse) != 0 || len(rb.prios) != 2 {
+		t.Logf("expected only the dense rings to stay allocated, got %d sparse rings and %d priorities\n",
+			len(rb.sparse), len(rb.prios))
+		t.Fail()
+	}
+	if err := rb.Validate(); err != nil {
+		t.Logf("unexpected error from Validate: %v\n", err)
+		t.Fail()
+	}
+}
+
+func testRingBufferForEachPriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWriteSticky(Critical, []byte("sticky0"))
+
+	var visited []string
+	rb.ForEachPriority(Critical, func(data []byte) bool {
+		visited = append(visited, string(data))
+		return true
+	})
+	if strings.Join(visited, ",") != "critical1,critical0" {
+		t.Logf("unexpected entries %q\n", visited)
+		t.Fail()
+	}
+
+	rb.ForEachPriority(Major, func(data []byte) bool {
+		t.Logf("unexpected Major entry %q\n", data)
+		t.Fail()
+		return true
+	})
+	popWithExpected("critical1", rb, false, t)
+}
+
+func testRingBufferPopWithRemaining(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+
+	for k, expected := range []string{"critical0", "minor0", "sticky0"} {
+		s, remaining, err := rb.PopWithRemaining()
+		if err != nil || s != expected || remaining != 2-k {
+			t.Logf("err: %v || %s != %s (%d remaining)\n", err, s, expected, remaining)
+			t.Fail()
+		}
+	}
+	if _, _, err := rb.PopWithRemaining(); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+
+	rb.SetStickyPop(false)
+	rb.PWriteSticky(Major, []byte("sticky1"))
+	rb.Write([]byte("minor1"))
+	if _, remaining, err := rb.PopWithRemaining(); err != nil || remaining != 0 {
+		t.Logf("unexpected result %d remaining, %v\n", remaining, err)
+		t.Fail()
+	}
+}
+
+func testRingBufferInspect(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor01"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.Pop(false)
+
+	expected := "[{Priority:Critical Capacity:3 WritePos:0 Slots:[{Occupied:false Len:0} {Occupied:false Len:0} {Occupied:false Len:0}]} " +
+		"{Priority:Minor Capacity:3 WritePos:2 Slots:[{Occupied:true Len:6} {Occupied:true Len:7} {Occupied:false Len:0}]}]"
+	if s := fmt.Sprintf("%+v", rb.Inspect()); s != expected {
+		t.Logf("%s != %s\n", s, expected)
+		t.Fail()
+	}
+
+	rb.Write([]byte("minor2"))
+	rb.Write([]byte("minor3"))
+	snaps := rb.Inspect()
+	if minor := snaps[1]; minor.WritePos != 1 || minor.Slots[0].Len != 6 || minor.Slots[2].Len != 6 {
+		t.Logf("unexpected snapshot after wrapping %+v\n", minor)
+		t.Fail()
+	}
+}
+
+func testRingBufferMemStats(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetStickyQuota(2)
+	rb.PWriteSticky(Major, []byte("sticky0"))
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+
+	entries, size, capEntries := rb.MemStats()
+	if entries != 3 || size != len("sticky0minor0critical0") || capEntries != 8 {
+		t.Logf("unexpected stats %d entries, %d bytes, %d capacity\n", entries, size, capEntries)
+		t.Fail()
+	}
+
+	rb.SetMaxEntries(4)
+	if _, _, capEntries := rb.MemStats(); capEntries != 6 {
+		t.Logf("expected capacity 6, got %d\n", capEntries)
+		t.Fail()
+	}
+}
+
+// testRingBufferHugePriority asserts that pops stay correct after writes at enormous
+// and tiny priorities. BenchmarkRingBufferHugePriority measures their speed
+func testRingBufferHugePriority(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(LogPriority(1<<30), []byte("huge0"))
+	rb.PWrite(LogPriority(-1<<30), []byte("tiny0"))
+	popWithExpected("huge0", rb, false, t)
+
+	for i := 0; i < 100000; i++ {
+		rb.Write([]byte("minor0"))
+		popWithExpected("minor0", rb, false, t)
+	}
+	popWithExpected("tiny0", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferSticky asserts that sticky entries survive overflow, respect their
+// quota, and are popped after every other entry
+func testRingBufferSticky(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetStickyQuota(2)
+	l := NewLogger(rb)
+
+	l.PrintSticky(Minor, "deployment=v1.2.3")
+	l.PrintSticky(Major, "region=us-east")
+	if _, err := rb.PWriteSticky(Critical, []byte("dropped")); err != ErrBufferFull {
+		t.Logf("expected %v, got %v\n", ErrBufferFull, err)
+		t.Fail()
+	}
+	l.Print(Minor, "0")
+	l.Print(Minor, "1")
+	l.Print(Minor, "2")
+	if n := rb.Len(); n != 4 {
+		t.Logf("expected 4 entries, got %d\n", n)
+		t.Fail()
+	}
+
+	popWithExpected("2", rb, false, t)
+	popWithExpected("1", rb, false, t)
+	if n := rb.Len(); n != 2 {
+		t.Logf("expected 2 sticky entries, got %d\n", n)
+		t.Fail()
+	}
+	popWithExpected("region=us-east", rb, false, t)
+	popWithExpected("deployment=v1.2.3", rb, false, t)
+	if n := rb.Len(); n != 0 {
+		t.Logf("expected 0 entries, got %d\n", n)
+		t.Fail()
+	}
+
+	rb.SetStickyPop(false)
+	l.PrintSticky(Minor, "deployment=v1.2.4")
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferPriorityComparator uses syslog-style ordering, where smaller values
+// are more urgent, and asserts that Pop and eviction respect it
+func testRingBufferPriorityComparator(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWrite(Trivial, []byte("trivial0"))
+	rb.SetPriorityComparator(func(a, b LogPriority) bool { return a > b })
+	rb.SetMaxEntries(3)
+
+	rb.PWrite(Major, []byte("major0"))
+	rb.PWrite(Minor, []byte("minor0"))
+
+	if !rb.HasAtLeast(Minor) || rb.HasPriority(Critical) {
+		t.Log("expected at least Minor and Critical evicted")
+		t.Fail()
+	}
+	popWithExpected("trivial0", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+	popWithExpected("major0", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testRingBufferPopMin(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+
+	if e, err := rb.PopMin(Major); err != nil || e.Message != "critical0" {
+		t.Logf("expected critical0, got %q, %v\n", e.Message, err)
+		t.Fail()
+	}
+	if _, err := rb.PopMin(Major); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+	popWithExpected("minor0", rb, false, t)
+
+	// neither a promoted entry nor drain weights below min hold up PopMin
+	rb.SetPromotionAge(time.Hour)
+	rb.SetDrainWeights(map[LogPriority]int{Critical: 1, Minor: 1})
+	rb.Write([]byte("minor1"))
+	backdate(rb, Minor, 2*time.Hour)
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWrite(Critical, []byte("critical2"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+	for _, expected := range []string{"critical2", "critical1", "sticky0"} {
+		if e, err := rb.PopMin(Major); err != nil || e.Message != expected {
+			t.Logf("expected %s, got %q, %v\n", expected, e.Message, err)
+			t.Fail()
+		}
+	}
+	if _, err := rb.PopMin(Major); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+	popWithExpected("minor1", rb, false, t)
+}
+
+// testRingBufferForEach asserts that ForEach visits entries in Pop order without
+// removing them, and stops when fn returns false
+func testRingBufferForEach(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWriteSticky(Trivial, []byte("sticky0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWriteSticky(Major, []byte("sticky1"))
+	rb.Write([]byte("minor1"))
+
+	var visited []string
+	rb.ForEach(func(p LogPriority, data []byte) bool {
+		visited = append(visited, string(data))
+		return true
+	})
+	expected := []string{"critical0", "minor1", "minor0", "sticky1", "sticky0"}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", visited, expected)
+		t.Fail()
+	}
+
+	visited = nil
+	rb.ForEach(func(p LogPriority, data []byte) bool {
+		visited = append(visited, string(data))
+		return p != Critical
+	})
+	if len(visited) != 1 {
+		t.Logf("expected traversal to stop after 1 entry, got %q\n", visited)
+		t.Fail()
+	}
+
+	for _, s := range expected {
+		popWithExpected(s, rb, false, t)
+	}
+}
+
+func testRingBufferCountFunc(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("dial timeout"))
+	rb.PWrite(Critical, []byte("read timeout"))
+	rb.PWrite(Critical, []byte("connection refused"))
+
+	n := rb.CountFunc(func(p LogPriority, data []byte) bool {
+		return p == Critical && bytes.Contains(data, []byte("timeout"))
+	})
+	if n != 1 {
+		t.Logf("expected 1, got %d\n", n)
+		t.Fail()
+	}
+}
+
+// testRingBufferBytes asserts that Bytes tracks entry lengths through writes,
+// overwrites, evictions, removals, and pops
+func testRingBufferBytes(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	bytesWithExpected(0, rb, t)
+
+	rb.Write([]byte("12"))
+	rb.Write([]byte("345"))
+	rb.PWriteSticky(Major, []byte("6"))
+	bytesWithExpected(6, rb, t)
+
+	rb.Write([]byte("7890")) // overwrites "12"
+	bytesWithExpected(8, rb, t)
+
+	rb.SetMaxEntries(2)
+	rb.PWrite(Critical, []byte("a")) // evicts "345"
+	bytesWithExpected(6, rb, t)
+
+	rb.removeEntry(Critical, []byte("a"))
+	bytesWithExpected(5, rb, t)
+
+	rb.Pop(false)
+	rb.Pop(false)
+	bytesWithExpected(0, rb, t)
+}
+
+func bytesWithExpected(expected int, rb *RingBuffer, t *testing.T) {
+	if n := rb.Bytes(); n != expected {
+		t.Logf("expected %d bytes, got %d\n", expected, n)
+		t.Fail()
+	}
+}
+
+func testRingBufferPartialFill(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.Write([]byte("0"))
+	rb.Write([]byte("1"))
+
+	popWithExpected("1", rb, false, t)
+	popWithExpected("0", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferGap clears a slot between stored entries and asserts that Pop skips
+// the unused slot instead of reporting the ring as empty
+func testRingBufferGap(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.Write([]byte("0"))
+	rb.Write([]byte("1"))
+	rb.Write([]byte("2"))
+
+	pr := rb.getRing(int(Minor))
+	pr.r.Prev().Value = nil // "2"
+	pr.n--
+	rb.total--
+
+	popWithExpected("1", rb, false, t)
+	rb.Write([]byte("3"))
+	popWithExpected("3", rb, false, t)
+	popWithExpected("0", rb, false, t)
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferZeroCopy asserts that the default RingBuffer is unaffected by
+// changes to a written slice, while a zero copy RingBuffer stores the slice itself
+func testRingBufferZeroCopy(t *testing.T) {
+	b := []byte("nemo")
+	rb := NewRingBuffer(Minor, 3)
+	zc := NewRingBuffer(Minor, 3, WithZeroCopy())
+	rb.Write(b)
+	zc.Write(b)
+
+	copy(b, "dory")
+	popWithExpected("nemo", rb, false, t)
+	popWithExpected("dory", zc, false, t)
+}
+
+func testRingBufferEach(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+
+	var visited []string
+	rb.Each(func(s string, p LogPriority) {
+		visited = append(visited, fmt.Sprintf("%s:%s", PriorityString(p), s))
+	})
+	expected := []string{"Critical:critical0", "Minor:minor0", "Major:sticky0"}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", visited, expected)
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testRingBufferPopSlot interleaves writes and pops, with and without overwriting,
+// and asserts the pop order and that no stored entry is cleared by a pop
+func testRingBufferPopSlot(t *testing.T) {
+	for _, tc := range []struct {
+		size     int
+		expected []string
+	}{
+		{5, []string{"4", "3", "1", "0"}},
+		{3, []string{"4", "3", "1"}}, // "4" overwrites "0"
+	} {
+		rb := NewRingBuffer(Minor, tc.size)
+		for _, s := range []string{"0", "1", "2"} {
+			rb.Write([]byte(s))
+			invariantsWithExpected(rb, t)
+		}
+		popWithExpected("2", rb, false, t)
+		invariantsWithExpected(rb, t)
+		for _, s := range []string{"3", "4"} {
+			rb.Write([]byte(s))
+			invariantsWithExpected(rb, t)
+		}
+
+		for _, s := range tc.expected {
+			popWithExpected(s, rb, false, t)
+			invariantsWithExpected(rb, t)
+		}
+		if _, err := rb.Pop(false); err != ErrBufferEmpty {
+			t.Logf("size %d: expected %v, got %v\n", tc.size, ErrBufferEmpty, err)
+			t.Fail()
+		}
+	}
+}
+
+func testRingBufferSeq(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	if seq := rb.Seq(); seq != 0 {
+		t.Logf("expected seq 0 before writing, got %d\n", seq)
+		t.Fail()
+	}
+
+	rb.Write([]byte("minor0"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	if seq := rb.Seq(); seq != 3 {
+		t.Logf("expected seq 3, got %d\n", seq)
+		t.Fail()
+	}
+	for _, seq := range []uint64{3, 1, 2} {
+		if e, err := rb.PopEntry(); err != nil || e.Seq != seq {
+			t.Logf("expected seq %d, got %d, %v\n", seq, e.Seq, err)
+			t.Fail()
+		}
+	}
+}
+
+// testRingBufferSeqContention asserts that entries written concurrently at one
+// priority pop newest first by sequence number, and that each writer's entries pop in
+// the reverse of the order it wrote them
+func testRingBufferSeqContention(t *testing.T) {
+	const writers, writes = 8, 100
+	rb := NewGrowingRingBuffer(Minor, 1, writers*writes)
 
 	var wg sync.WaitGroup
-	for i := 0; i < 5; i++ {
+	for w := 0; w < writers; w++ {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
+		go func(w int) {
 			defer wg.Done()
-			l.Lock()
-			defer l.Unlock()
-			l.Append("n")
-			l.Append("e")
-			l.Append("m")
-			l.Append("o")
-			l.AppendDone(Major)
-		}(&wg)
+			for i := 0; i < writes; i++ {
+				rb.PWrite(Major, []byte(fmt.Sprintf("%d %d", w, i)))
+			}
+		}(w)
 	}
 	wg.Wait()
 
+	last := make(map[int]int)
+	prevSeq := rb.Seq() + 1
+	for k := 0; k < writers*writes; k++ {
+		e, err := rb.PopEntry()
+		if err != nil {
+			t.Fatalf("unexpected pop error after %d entries: %v\n", k, err)
+		}
+		var w, i int
+		fmt.Sscanf(e.Message, "%d %d", &w, &i)
+		if e.Seq >= prevSeq {
+			t.Logf("seq %d popped after seq %d\n", e.Seq, prevSeq)
+			t.Fail()
+		}
+		if prev, ok := last[w]; ok && i >= prev {
+			t.Logf("writer %d entry %d popped after entry %d\n", w, i, prev)
+			t.Fail()
+		}
+		prevSeq, last[w] = e.Seq, i
+	}
+}
+
+// testRingBufferInterning asserts that identical bodies share storage while interning
+// is enabled and that popped entries are unaffected
+func testRingBufferInterning(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.SetInterning(true)
+	rb.Write([]byte("nemo"))
+	rb.Write([]byte("nemo"))
+	rb.Write([]byte("dory"))
+
+	var data [][]byte
+	rb.ForEach(func(p LogPriority, b []byte) bool {
+		data = append(data, b)
+		return true
+	})
+	if &data[1][0] != &data[2][0] || &data[0][0] == &data[1][0] {
+		t.Logf("expected only the identical bodies to share storage\n")
+		t.Fail()
+	}
+
+	rb.SetInterning(false)
+	rb.Write([]byte("nemo"))
 	popWithExpected("nemo", rb, false, t)
-	popWithExpected("nemo", rb, false, t)
+	popWithExpected("dory", rb, false, t)
 	popWithExpected("nemo", rb, false, t)
 }
 
-// TestRingBuffer runs a variety of subtests covering RingBuffer usage
-func TestRingBuffer(t *testing.T) {
-	t.Run("get priority", testRingBufferGetPriority)
-	t.Run("set priority", testRingBufferSetPriority)
-	t.Run("write", testRingBufferWrite)
-	t.Run("pwrite", testRingBufferPWrite)
-	t.Run("pop", testRingBufferPop)
-	t.Run("overflow", testRingBufferOverflow)
+func testRingBufferDrainWeights(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	for i := 0; i < 5; i++ {
+		rb.PWrite(Critical, []byte(fmt.Sprint("critical", i)))
+		rb.PWrite(Major, []byte(fmt.Sprint("major", i)))
+	}
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor1"))
+	rb.SetDrainWeights(map[LogPriority]int{Critical: 2, Major: 0})
+
+	expected := []string{
+		"critical4", "critical3", "major4", "minor1",
+		"critical2", "critical1", "major3", "minor0",
+		"critical0", "major2",
+		"major1",
+		"major0",
+	}
+	for _, s := range expected {
+		popWithExpected(s, rb, false, t)
+	}
+
+	rb.PWrite(Critical, []byte("critical5"))
+	rb.Write([]byte("minor2"))
+	rb.SetDrainWeights(nil)
+	rb.PWrite(Critical, []byte("critical6"))
+	popWithExpected("critical6", rb, false, t)
+	popWithExpected("critical5", rb, false, t)
+	popWithExpected("minor2", rb, false, t)
 }
 
-func testRingBufferGetPriority(t *testing.T) {
-	rb := NewRingBuffer(Minor, 3)
-	p := rb.GetPriority()
-	if p != Minor {
-		t.Logf("expected %v, got %v\n", Minor, p)
+// testRingBufferPromotionAge asserts that aged entries are popped oldest first ahead
+// of younger, higher priority entries
+func testRingBufferPromotionAge(t *testing.T) {
+	rb := NewRingBuffer(Minor, 5)
+	rb.SetPromotionAge(time.Hour)
+	rb.Write([]byte("minor0"))
+	rb.Write([]byte("minor1"))
+	rb.PWrite(Critical, []byte("critical0"))
+	popWithExpected("critical0", rb, false, t)
+
+	backdate(rb, Minor, 2*time.Hour)
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWrite(Critical, []byte("critical2"))
+	for _, s := range []string{"minor0", "minor1", "critical2"} {
+		popWithExpected(s, rb, false, t)
+	}
+
+	// WriteTo writes in the same order as Pop
+	rb.Write([]byte("minor2"))
+	backdate(rb, Minor, 2*time.Hour)
+	var out bytes.Buffer
+	rb.WriteTo(&out)
+	if expected := "minor2\ncritical1\n"; out.String() != expected {
+		t.Logf("%q != %q\n", out.String(), expected)
 		t.Fail()
 	}
 }
 
-func testRingBufferSetPriority(t *testing.T) {
-	rb := NewRingBuffer(Minor, 3)
-	rb.SetPriority(Major)
-	p := rb.GetPriority()
-	if p != Major {
-		t.Logf("expected %v, got %v\n", Major, p)
+// backdate moves the write time of rb's entries with priority p back by d, so tests
+// of age-based behavior don't depend on sleeping
+func backdate(rb *RingBuffer, p LogPriority, d time.Duration) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	if pr := rb.getRing(int(p)); pr != nil {
+		pr.walk(func(rg *ring.Ring) bool {
+			e := rg.Value.(*entry)
+			e.t = e.t.Add(-d)
+			return true
+		})
+	}
+}
+
+// testRingBufferConcurrentPop runs Pop alongside Write, PWrite, and SetPriority so
+// that -race reports any unlocked access, then asserts that no entry was lost
+func testRingBufferConcurrentPop(t *testing.T) {
+	rb := NewRingBuffer(Minor, 1000)
+
+	var popped int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rb.SetPriority(LogPriority(j % 4))
+				rb.Write([]byte("nemo"))
+				rb.PWrite(LogPriority(i), []byte("dory"))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := rb.Pop(true); err == nil {
+					atomic.AddInt64(&popped, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total := popped + int64(len(rb.PopAll(false))); total != 800 {
+		t.Logf("expected 800 entries, got %d\n", total)
 		t.Fail()
 	}
 }
 
-func testRingBufferWrite(t *testing.T) {
+func testRingBufferLen(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	lenWithExpected := func(expected, critical int) {
+		if n, c := rb.Len(), rb.LenPriority(Critical); n != expected || c != critical {
+			t.Logf("expected %d entries with %d Critical, got %d with %d\n", expected, critical, n, c)
+			t.Fail()
+		}
+	}
+	lenWithExpected(0, 0)
+
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWrite(Critical, []byte("critical1"))
+	rb.PWrite(Critical, []byte("critical2"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+	lenWithExpected(4, 2)
+
+	rb.Pop(false)
+	lenWithExpected(3, 1)
+}
+
+// testRingBufferValidate asserts that Validate accepts the state left by a mix of
+// operations and reports a corrupted count
+func testRingBufferValidate(t *testing.T) {
+	rb := NewGrowingRingBuffer(Minor, 1, 4)
+	rb.SetMaxEntries(6)
+	steps := []func(){
+		func() { rb.Write([]byte("minor0")) },
+		func() { rb.PWrite(Critical, []byte("critical0")) },
+		func() { rb.PWrite(LogPriority(100), []byte("sparse0")) },
+		func() { rb.PWriteSticky(Major, []byte("sticky0")) },
+		func() {
+			for i := 0; i < 6; i++ {
+				rb.PWrite(Major, []byte(fmt.Sprint("major", i)))
+			}
+		},
+		func() { rb.Pop(false) },
+		func() { rb.Compact() },
+		func() { rb.PopAll(false) },
+	}
+	for i, step := range steps {
+		step()
+		if err := rb.Validate(); err != nil {
+			t.Logf("step %d: unexpected error: %v\n", i, err)
+			t.Fail()
+		}
+	}
+
+	rb.Write([]byte("minor1"))
+	rb.total++
+	if err := rb.Validate(); err == nil {
+		t.Log("expected an error for a corrupted count")
+		t.Fail()
+	}
+}
+
+func testRingBufferTryPopWait(t *testing.T) {
 	rb := NewRingBuffer(Minor, 3)
-	rb.Write([]byte("nemo"))
-	popWithExpected("nemo", rb, false, t)
+	if _, err := rb.TryPopWait(time.Millisecond); err != ErrTimeout {
+		t.Logf("expected %v, got %v\n", ErrTimeout, err)
+		t.Fail()
+	}
+
+	rb.Write([]byte("minor0"))
+	if s, err := rb.TryPopWait(time.Millisecond); err != nil || s != "minor0" {
+		t.Logf("err: %v || %s != minor0\n", err, s)
+		t.Fail()
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rb.Write([]byte("minor1"))
+	}()
+	if s, err := rb.TryPopWait(time.Second); err != nil || s != "minor1" {
+		t.Logf("err: %v || %s != minor1\n", err, s)
+		t.Fail()
+	}
 }
 
-func testRingBufferPWrite(t *testing.T) {
+// testRingBufferCompact asserts that Compact shrinks rings to their occupancy and that
+// compacted rings grow back to the RingBuffer's capacity
+func testRingBufferCompact(t *testing.T) {
+	rb := NewRingBuffer(Minor, 8)
+	for i := 0; i < 8; i++ {
+		rb.Write([]byte(fmt.Sprint("minor", i)))
+	}
+	rb.PWrite(Major, []byte("major0"))
+	popWithExpected("major0", rb, false, t)
+	for i := 7; i >= 6; i-- {
+		popWithExpected(fmt.Sprint("minor", i), rb, false, t)
+	}
+
+	rb.Compact()
+	invariantsWithExpected(rb, t)
+	if slots := rb.getRing(int(Minor)).r.Len(); slots != 6 {
+		t.Logf("expected 6 slots after Compact, got %d\n", slots)
+		t.Fail()
+	}
+	if slots := rb.getRing(int(Major)).r.Len(); slots != 1 {
+		t.Logf("expected 1 slot after Compact, got %d\n", slots)
+		t.Fail()
+	}
+
+	for i := 8; i < 12; i++ {
+		rb.Write([]byte(fmt.Sprint("minor", i)))
+		invariantsWithExpected(rb, t)
+	}
+	if slots := rb.getRing(int(Minor)).r.Len(); slots != 8 {
+		t.Logf("expected ring to grow back to 8 slots, got %d\n", slots)
+		t.Fail()
+	}
+	for _, i := range []int{11, 10, 9, 8, 5, 4, 3, 2} {
+		popWithExpected(fmt.Sprint("minor", i), rb, false, t)
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testRingBufferPWriteBatch(t *testing.T) {
+	rb := NewRingBuffer(Minor, 2)
+	rb.SetOverflowPolicy(RejectNewest)
+	batch := [][]byte{[]byte("major0"), []byte("major1"), []byte("major2")}
+	if n, err := rb.PWriteBatch(Major, batch); err != ErrBufferFull || n != 2 {
+		t.Logf("expected 2, %v, got %d, %v\n", ErrBufferFull, n, err)
+		t.Fail()
+	}
+	popWithExpected("major1", rb, false, t)
+	popWithExpected("major0", rb, false, t)
+}
+
+func testRingBufferGrowing(t *testing.T) {
+	rb := NewGrowingRingBuffer(Minor, 2, 5)
+	slots := []int{2, 2, 4, 4, 5, 5, 5}
+	for i, expected := range slots {
+		rb.Write([]byte(fmt.Sprint("minor", i)))
+		invariantsWithExpected(rb, t)
+		if n := rb.getRing(int(Minor)).r.Len(); n != expected {
+			t.Logf("write %d: expected %d slots, got %d\n", i, expected, n)
+			t.Fail()
+		}
+	}
+	for i := 6; i >= 2; i-- {
+		popWithExpected(fmt.Sprint("minor", i), rb, false, t)
+	}
+
+	rb.Compact()
+	if n := rb.getRing(int(Minor)).r.Len(); n != 1 {
+		t.Logf("expected 1 slot after Compact, got %d\n", n)
+		t.Fail()
+	}
+}
+
+// testRingBufferPopP asserts that entries written at the default priority keep the
+// priority that was the default when they were written
+func testRingBufferPopP(t *testing.T) {
 	rb := NewRingBuffer(Minor, 3)
-	rb.PWrite(Major, []byte("nemo"))
-	popWithExpected("nemo", rb, false, t)
+	rb.SetPriority(Major)
+	rb.Write([]byte("major0"))
+	rb.SetPriority(Trivial)
+	rb.Write([]byte("trivial0"))
+
+	for _, expected := range []struct {
+		s string
+		p LogPriority
+	}{
+		{"major0", Major},
+		{"trivial0", Trivial},
+	} {
+		if s, p, err := rb.PopP(); err != nil || s != expected.s || p != expected.p {
+			t.Logf("err: %v || %s, %v != %s, %v\n", err, s, p, expected.s, expected.p)
+			t.Fail()
+		}
+	}
+	if _, _, err := rb.PopP(); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
 }
 
-// testRingBufferPop inserts some strings in random order and asserts that they are
-// popped in the correct order
-func testRingBufferPop(t *testing.T) {
+func testRingBufferTail(t *testing.T) {
 	rb := NewRingBuffer(Minor, 3)
-	rb.PWrite(Major, []byte("major0"))
 	rb.Write([]byte("minor0"))
-	rb.PWrite(Trivial, []byte("trivial0"))
 	rb.PWrite(Critical, []byte("critical0"))
-	rb.PWrite(Major, []byte("major1"))
+	rb.PWriteSticky(Trivial, []byte("sticky0"))
 	rb.Write([]byte("minor1"))
-	rb.PWrite(Critical, []byte("critical1"))
-	rb.PWrite(Trivial, []byte("trivial1"))
+	rb.PWrite(Major, []byte("major0"))
 
-	popWithExpected("critical1", rb, false, t)
+	var tail []string
+	for _, e := range rb.Tail(3) {
+		tail = append(tail, e.Message)
+	}
+	expected := []string{"sticky0", "minor1", "major0"}
+	if fmt.Sprint(tail) != fmt.Sprint(expected) {
+		t.Logf("%q != %q\n", tail, expected)
+		t.Fail()
+	}
+	if n := len(rb.Tail(10)); n != 5 {
+		t.Logf("expected 5 entries, got %d\n", n)
+		t.Fail()
+	}
 	popWithExpected("critical0", rb, false, t)
-	popWithExpected("major1", rb, false, t)
-	popWithExpected("major0", rb, false, t)
-	popWithExpected("minor1", rb, false, t)
-	popWithExpected("minor0", rb, false, t)
-	popWithExpected("trivial1", rb, false, t)
-	popWithExpected("trivial0", rb, false, t)
 }
 
-// testRingBufferOverflow asserts that RingBuffer displays proper overflow behavior
-// for a ring buffer that has had more entries than its capacity inserted
-func testRingBufferOverflow(t *testing.T) {
-	rb := NewRingBuffer(Minor, 5)
-	rb.Write([]byte("0"))
-	rb.Write([]byte("1"))
-	rb.Write([]byte("2"))
-	rb.Write([]byte("3"))
-	rb.Write([]byte("4"))
-	rb.Write([]byte("5"))
-	rb.Write([]byte("6"))
+// BenchmarkLoggerAppendSession assembles entries in concurrent append sessions
+func BenchmarkLoggerAppendSession(b *testing.B) {
+	l := NewLogger(NewRingBuffer(Minor, 1024))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tok := l.BeginAppend()
+			l.AppendTo(tok, "request failed: ")
+			l.AppendTo(tok, "upstream returned 503 Service Unavailable")
+			l.CommitAppend(tok, Major)
+		}
+	})
+}
 
-	popWithExpected("6", rb, false, t)
-	popWithExpected("5", rb, false, t)
-	popWithExpected("4", rb, false, t)
-	popWithExpected("3", rb, false, t)
-	popWithExpected("2", rb, false, t)
-	if _, err := rb.Pop(false); err == nil {
-		t.Log("err should not be nil")
-		t.Fail()
+// BenchmarkRingBufferInterning compares memory use with and without interning on a
+// workload that repeats a few messages
+func BenchmarkRingBufferInterning(b *testing.B) {
+	msgs := make([][]byte, 8)
+	for i := range msgs {
+		msgs[i] = []byte(fmt.Sprintf("request failed: upstream %d returned 503 Service Unavailable", i))
+	}
+
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("interning=%t", enabled), func(b *testing.B) {
+			b.ReportAllocs()
+			rb := NewRingBuffer(Minor, 1024)
+			rb.SetInterning(enabled)
+			for i := 0; i < b.N; i++ {
+				rb.PWrite(Major, msgs[i%len(msgs)])
+			}
+		})
+	}
+}
+
+// BenchmarkRingBufferCopy compares writing with the default copy-on-write behavior
+// against WithZeroCopy
+func BenchmarkRingBufferCopy(b *testing.B) {
+	data := bytes.Repeat([]byte("nemo"), 256)
+	for _, bc := range []struct {
+		name string
+		opts []RingBufferOption
+	}{
+		{"copy", nil},
+		{"zero copy", []RingBufferOption{WithZeroCopy()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			rb := NewRingBuffer(Minor, 16, bc.opts...)
+			for i := 0; i < b.N; i++ {
+				rb.PWrite(Major, data)
+			}
+		})
+	}
+}
+
+// BenchmarkRingBufferRefill compares draining and refilling a RingBuffer in place
+// against allocating a fresh RingBuffer for every cycle
+func BenchmarkRingBufferRefill(b *testing.B) {
+	data := []byte("nemo")
+	fill := func(rb *RingBuffer) {
+		for p := Trivial; p <= Critical; p++ {
+			for i := 0; i < 16; i++ {
+				rb.PWrite(p, data)
+			}
+		}
+	}
+
+	b.Run("pop all", func(b *testing.B) {
+		b.ReportAllocs()
+		rb := NewRingBuffer(Minor, 16)
+		for i := 0; i < b.N; i++ {
+			fill(rb)
+			rb.PopAll(false)
+		}
+	})
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		rb := NewRingBuffer(Minor, 16)
+		for i := 0; i < b.N; i++ {
+			fill(rb)
+			rb.Reset()
+		}
+	})
+	b.Run("realloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rb := NewRingBuffer(Minor, 16)
+			fill(rb)
+		}
+	})
+}
+
+// BenchmarkRingBufferPWritePop measures the write and pop hot path across the
+// predefined priorities
+func BenchmarkRingBufferPWritePop(b *testing.B) {
+	b.ReportAllocs()
+	rb := NewRingBuffer(Minor, 16)
+	data := []byte("nemo")
+	for i := 0; i < b.N; i++ {
+		for p := Trivial; p <= Critical; p++ {
+			rb.PWrite(p, data)
+		}
+		for p := Trivial; p <= Critical; p++ {
+			rb.Pop(false)
+		}
+	}
+}
+
+// BenchmarkRingBufferHighPriority writes and pops a single entry with a large priority
+// value, which exercises the highP recovery done by every Pop
+func BenchmarkRingBufferHighPriority(b *testing.B) {
+	b.ReportAllocs()
+	rb := NewRingBuffer(Minor, 16)
+	data := []byte("nemo")
+	for i := 0; i < b.N; i++ {
+		rb.PWrite(LogPriority(1000), data)
+		rb.Pop(false)
+	}
+}
+
+// BenchmarkRingBufferHugePriority writes and pops an entry while an entry with a tiny
+// priority is buffered and one with an enormous priority has been popped. Its ns/op
+// should be close to the per entry cost of BenchmarkRingBufferPWritePop rather than
+// growing with the distance between the priorities
+func BenchmarkRingBufferHugePriority(b *testing.B) {
+	b.ReportAllocs()
+	rb := NewRingBuffer(Minor, 16)
+	rb.PWrite(LogPriority(1<<30), []byte("huge0"))
+	rb.PWrite(LogPriority(-1<<30), []byte("tiny0"))
+	rb.Pop(false)
+	data := []byte("nemo")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Write(data)
+		rb.Pop(false)
 	}
 }
 
@@ -131,3 +2260,11 @@ func popWithExpected(expected string, rb *RingBuffer, prefix bool, t *testing.T)
 		t.Fail()
 	}
 }
+
+// invariantsWithExpected asserts that the RingBuffer's internal invariants hold
+func invariantsWithExpected(rb *RingBuffer, t *testing.T) {
+	if err := rb.Validate(); err != nil {
+		t.Logf("invalid RingBuffer: %v\n", err)
+		t.Fail()
+	}
+}
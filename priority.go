@@ -0,0 +1,77 @@
+package plog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// canonicalPriorities maps the lowercased names returned by PriorityString to their
+// priorities
+var canonicalPriorities = map[string]LogPriority{
+	"trivial":  Trivial,
+	"minor":    Minor,
+	"major":    Major,
+	"critical": Critical,
+}
+
+var (
+	aliasLock  sync.RWMutex
+	aliases    = make(map[string]LogPriority) // lowercased alias to priority
+	aliasNames = make(map[LogPriority]string) // priority to its most recent alias
+)
+
+// RegisterPriorityAlias registers name as an alias for p, such as "P0" for Critical
+// Once registered, PriorityString and String return the alias in place of p's
+// canonical name, and ParsePriority accepts it. If p has several aliases, the most
+// recently registered one is returned. Aliases are matched case-insensitively
+// It returns an error if name is a canonical priority name or is already an alias
+// for a different priority. Aliases are global to the package, so they are best
+// registered during initialization
+func RegisterPriorityAlias(name string, p LogPriority) error {
+	key := strings.ToLower(name)
+	if _, ok := canonicalPriorities[key]; ok || key == "unsupported" {
+		return fmt.Errorf("plog: priority alias %q collides with a canonical priority name", name)
+	}
+
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
+
+	if q, ok := aliases[key]; ok && q != p {
+		return fmt.Errorf("plog: priority alias %q is already registered for %s", name, priorityName(q))
+	}
+	aliases[key] = p
+	aliasNames[p] = name
+	return nil
+}
+
+// ParsePriority returns the priority named by s, which may be a canonical priority
+// name or a registered alias. Names are matched case-insensitively
+func ParsePriority(s string) (LogPriority, error) {
+	key := strings.ToLower(s)
+	if p, ok := canonicalPriorities[key]; ok {
+		return p, nil
+	}
+
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+
+	if p, ok := aliases[key]; ok {
+		return p, nil
+	}
+	return 0, fmt.Errorf("plog: unknown priority %q", s)
+}
+
+// String returns p's name as returned by PriorityString, implementing fmt.Stringer
+func (p LogPriority) String() string {
+	return PriorityString(p)
+}
+
+// aliasName returns the alias registered most recently for p, if any
+func aliasName(p LogPriority) (string, bool) {
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+
+	name, ok := aliasNames[p]
+	return name, ok
+}
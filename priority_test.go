@@ -0,0 +1,68 @@
+package plog
+
+import (
+	"testing"
+)
+
+// TestPriorityAlias runs subtests covering priority names and aliases
+func TestPriorityAlias(t *testing.T) {
+	t.Run("parse", testPriorityAliasParse)
+	t.Run("register", testPriorityAliasRegister)
+}
+
+func testPriorityAliasParse(t *testing.T) {
+	for s, expected := range map[string]LogPriority{
+		"Critical": Critical,
+		"major":    Major,
+		"MINOR":    Minor,
+		"trivial":  Trivial,
+	} {
+		if p, err := ParsePriority(s); err != nil || p != expected {
+			t.Logf("ParsePriority(%q): expected %v, got %v, %v\n", s, expected, p, err)
+			t.Fail()
+		}
+	}
+	if _, err := ParsePriority("nemo"); err == nil {
+		t.Log("err should not be nil")
+		t.Fail()
+	}
+}
+
+func testPriorityAliasRegister(t *testing.T) {
+	defer resetPriorityAliases()
+
+	if err := RegisterPriorityAlias("P0", Critical); err != nil {
+		t.Logf("unexpected register error: %v\n", err)
+		t.Fail()
+	}
+	if err := RegisterPriorityAlias("major", Minor); err == nil {
+		t.Log("expected an error for an alias colliding with a canonical name")
+		t.Fail()
+	}
+	if err := RegisterPriorityAlias("p0", Major); err == nil {
+		t.Log("expected an error for an alias registered to another priority")
+		t.Fail()
+	}
+
+	if p, err := ParsePriority("p0"); err != nil || p != Critical {
+		t.Logf("expected %v, got %v, %v\n", Critical, p, err)
+		t.Fail()
+	}
+	if s := Critical.String(); s != "P0" {
+		t.Logf("expected P0, got %s\n", s)
+		t.Fail()
+	}
+
+	rb := NewRingBuffer(Minor, 3)
+	rb.PWrite(Critical, []byte("critical0"))
+	popWithExpected("P0 critical0", rb, true, t)
+}
+
+// resetPriorityAliases removes every registered priority alias
+func resetPriorityAliases() {
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
+
+	clear(aliases)
+	clear(aliasNames)
+}
@@ -0,0 +1,212 @@
+package plog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ProtoEncoder is an Encoder that writes each entry as a length-delimited protocol
+// buffer message, as read by parseDelimitedFrom and similar functions, with this
+// schema:
+//
+//	message Entry {
+//	  string message = 1;
+//	  int64 priority = 2;
+//	  int64 time_unix_nano = 3;
+//	  map<string, string> fields = 4;
+//	  uint64 seq = 5;
+//	}
+//
+// The wire format is written directly, so using it doesn't add a dependency on a
+// protocol buffer library. Unknown fields are skipped when decoding
+type ProtoEncoder struct{}
+
+// protocol buffer wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// errProto is returned when decoding a malformed protocol buffer message
+var errProto = errors.New("plog: malformed protocol buffer Entry")
+
+// Encode writes e to w as a length-delimited message in a single call to w.Write
+func (ProtoEncoder) Encode(w io.Writer, e Entry) error {
+	_, err := w.Write(appendProtoDelimited(nil, e))
+	return err
+}
+
+// appendProtoDelimited appends the length-delimited message encoding e to buf
+func appendProtoDelimited(buf []byte, e Entry) []byte {
+	msg := appendProto(nil, e)
+	buf = binary.AppendUvarint(buf, uint64(len(msg)))
+	return append(buf, msg...)
+}
+
+// appendProto appends the message encoding e to buf, omitting fields with default
+// values
+func appendProto(buf []byte, e Entry) []byte {
+	if e.Message != "" {
+		buf = appendProtoString(buf, 1, e.Message)
+	}
+	if e.Priority != 0 {
+		buf = appendProtoTag(buf, 2, protoVarint)
+		buf = binary.AppendUvarint(buf, uint64(e.Priority))
+	}
+	if !e.Time.IsZero() {
+		buf = appendProtoTag(buf, 3, protoVarint)
+		buf = binary.AppendUvarint(buf, uint64(e.Time.UnixNano()))
+	}
+
+	// sort the keys so that equal entries always encode identically
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kv := appendProtoString(nil, 1, k)
+		kv = appendProtoString(kv, 2, e.Fields[k])
+		buf = appendProtoTag(buf, 4, protoBytes)
+		buf = binary.AppendUvarint(buf, uint64(len(kv)))
+		buf = append(buf, kv...)
+	}
+
+	if e.Seq != 0 {
+		buf = appendProtoTag(buf, 5, protoVarint)
+		buf = binary.AppendUvarint(buf, e.Seq)
+	}
+	return buf
+}
+
+func appendProtoTag(buf []byte, num, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(num<<3|wireType))
+}
+
+func appendProtoString(buf []byte, num int, s string) []byte {
+	buf = appendProtoTag(buf, num, protoBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Decode reads the next length-delimited message from r
+func (ProtoEncoder) Decode(r *bufio.Reader) (Entry, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return Entry{}, err
+	}
+	if err != nil {
+		return Entry{}, io.ErrUnexpectedEOF
+	}
+
+	// copy as the message is read, so a corrupt length can't exhaust memory
+	var msg bytes.Buffer
+	if n, _ := io.CopyN(&msg, r, int64(size)); uint64(n) != size {
+		return Entry{}, io.ErrUnexpectedEOF
+	}
+	return parseProto(msg.Bytes())
+}
+
+// parseProto decodes the message encoding an Entry
+func parseProto(msg []byte) (Entry, error) {
+	var e Entry
+	for len(msg) > 0 {
+		num, wireType, v, b, rest, err := nextProtoField(msg)
+		if err != nil {
+			return Entry{}, err
+		}
+		msg = rest
+
+		switch {
+		case num == 1 && wireType == protoBytes:
+			e.Message = string(b)
+		case num == 2 && wireType == protoVarint:
+			e.Priority = LogPriority(int64(v))
+		case num == 3 && wireType == protoVarint:
+			e.Time = time.Unix(0, int64(v))
+		case num == 4 && wireType == protoBytes:
+			var k, val string
+			for len(b) > 0 {
+				num, wireType, _, s, rest, err := nextProtoField(b)
+				if err != nil {
+					return Entry{}, err
+				}
+				b = rest
+				if wireType == protoBytes && num == 1 {
+					k = string(s)
+				} else if wireType == protoBytes && num == 2 {
+					val = string(s)
+				}
+			}
+			if e.Fields == nil {
+				e.Fields = make(map[string]string)
+			}
+			e.Fields[k] = val
+		case num == 5 && wireType == protoVarint:
+			e.Seq = v
+		}
+	}
+	return e, nil
+}
+
+// nextProtoField reads the field at the start of msg, returning its number and wire
+// type, its value if it's a varint, its contents if it's length-delimited, and the
+// rest of msg
+func nextProtoField(msg []byte) (num, wireType int, v uint64, b, rest []byte, err error) {
+	tag, n := binary.Uvarint(msg)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errProto
+	}
+	msg = msg[n:]
+	num, wireType = int(tag>>3), int(tag&7)
+
+	switch wireType {
+	case protoVarint:
+		v, n = binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, 0, 0, nil, nil, errProto
+		}
+		return num, wireType, v, nil, msg[n:], nil
+	case protoFixed64, protoFixed32:
+		size := 8
+		if wireType == protoFixed32 {
+			size = 4
+		}
+		if len(msg) < size {
+			return 0, 0, 0, nil, nil, errProto
+		}
+		return num, wireType, 0, nil, msg[size:], nil
+	case protoBytes:
+		size, n := binary.Uvarint(msg)
+		if n <= 0 || uint64(len(msg)-n) < size {
+			return 0, 0, 0, nil, nil, errProto
+		}
+		msg = msg[n:]
+		return num, wireType, 0, msg[:size], msg[size:], nil
+	}
+	return 0, 0, 0, nil, nil, fmt.Errorf("plog: unsupported protocol buffer wire type %d", wireType)
+}
+
+// FlushProto pops every entry from the Logger's Buffer and writes it to w as a
+// length-delimited protocol buffer message, as encoded by ProtoEncoder. The Logger's
+// format isn't applied. Otherwise, FlushProto behaves like FlushTo
+func (l *Logger) FlushProto(w io.Writer) error {
+	l.bufLock.RLock()
+	defer l.releaseBuf()
+
+	_, failed, err := l.flushTo(w, func(e Entry) ([]byte, error) {
+		return appendProtoDelimited(nil, e), nil
+	})
+	if err != nil {
+		l.queueDeadLetter(failed)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package plog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestProtoEncoder runs subtests covering the protocol buffer encoding
+func TestProtoEncoder(t *testing.T) {
+	t.Run("wire format", testProtoEncoderWireFormat)
+	t.Run("round trip", testProtoEncoderRoundTrip)
+	t.Run("flush", testProtoEncoderFlush)
+}
+
+func testProtoEncoderWireFormat(t *testing.T) {
+	var buf bytes.Buffer
+	ProtoEncoder{}.Encode(&buf, Entry{Message: "hi", Priority: Major})
+
+	expected := []byte{6, 0x0a, 2, 'h', 'i', 0x10, 2}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Logf("%x != %x\n", buf.Bytes(), expected)
+		t.Fail()
+	}
+}
+
+func testProtoEncoderRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{
+			Message:  "minor0\nminor0",
+			Priority: Minor,
+			Time:     time.Unix(0, 1234),
+			Fields:   map[string]string{"trace_id": "abc123", "user": ""},
+			Seq:      7,
+		},
+		{Message: "sparse0", Priority: LogPriority(-3)},
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		ProtoEncoder{}.Encode(&buf, e)
+	}
+	r := bufio.NewReader(&buf)
+	for _, expected := range entries {
+		e, err := ProtoEncoder{}.Decode(r)
+		if err != nil || fmt.Sprint(e) != fmt.Sprint(expected) || !e.Time.Equal(expected.Time) {
+			t.Logf("err: %v || %+v != %+v\n", err, e, expected)
+			t.Fail()
+		}
+	}
+	if _, err := (ProtoEncoder{}).Decode(r); err != io.EOF {
+		t.Logf("expected %v, got %v\n", io.EOF, err)
+		t.Fail()
+	}
+}
+
+// testProtoEncoderFlush asserts that FlushProto writes records that decode to the
+// buffered entries and that a FileBuffer can store them
+func testProtoEncoderFlush(t *testing.T) {
+	l := NewLogger(NewRingBuffer(Minor, 3))
+	l.SetFormat("{priority}: {msg}")
+	l.Print(Minor, "minor0")
+	l.Print(Critical, "critical0")
+
+	var out bytes.Buffer
+	if err := l.FlushProto(&out); err != nil {
+		t.Logf("unexpected error: %v\n", err)
+		t.Fail()
+	}
+	r := bufio.NewReader(&out)
+	for _, expected := range []string{"critical0", "minor0"} {
+		if e, err := (ProtoEncoder{}).Decode(r); err != nil || e.Message != expected {
+			t.Logf("err: %v || %s != %s\n", err, e.Message, expected)
+			t.Fail()
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "plog")
+	fb, err := NewFileBuffer(path, Minor, ProtoEncoder{})
+	if err != nil {
+		t.Fatalf("unexpected open error: %v\n", err)
+	}
+	fb.PWrite(Major, []byte("major0"))
+	fb.Close()
+
+	fb, err = NewFileBuffer(path, Minor, ProtoEncoder{})
+	if err != nil {
+		t.Fatalf("unexpected reopen error: %v\n", err)
+	}
+	defer fb.Close()
+	if s, err := fb.Pop(true); err != nil || s != "Major major0" {
+		t.Logf("err: %v || %s != %s\n", err, s, "Major major0")
+		t.Fail()
+	}
+}
@@ -0,0 +1,212 @@
+package plog
+
+import (
+	"bufio"
+	"container/ring"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxLineLength is the longest line ReadFrom stores by default
+const DefaultMaxLineLength = 64 << 10
+
+// EntryReader drains a RingBuffer one entry at a time through the io.Reader interface
+// Each entry is followed by a newline, unless it already ends with one, so consumers
+// can split the stream back into entries. Each Read returns at most one entry; if p is
+// too small to hold it, the rest of the entry is returned by the following Reads
+// before the next entry is popped
+type EntryReader struct {
+	r       *RingBuffer
+	pending []byte
+}
+
+// NewEntryReader returns an EntryReader that pops entries from the RingBuffer in the
+// same order as Pop. Read returns io.EOF once the RingBuffer is empty; entries written
+// after that are returned by subsequent Reads
+func (r *RingBuffer) NewEntryReader() io.Reader {
+	return &EntryReader{r: r}
+}
+
+// Read implements io.Reader
+func (er *EntryReader) Read(p []byte) (int, error) {
+	if len(er.pending) == 0 {
+		e, err := er.r.PopEntry()
+		if err == ErrBufferEmpty {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+		er.pending = entryLine([]byte(e.Message))
+	}
+
+	n := copy(p, er.pending)
+	er.pending = er.pending[n:]
+	return n, nil
+}
+
+// WriteTo writes the RingBuffer's entries to w in the same order as Pop, framed like
+// EntryReader, implementing io.WriterTo. Unlike draining with Pop, each entry is only
+// removed once it has been written in full, so if a write fails, the entry being
+// written and every entry after it stay buffered and a later WriteTo can retry them
+// The RingBuffer isn't locked while w is written to, so other goroutines may keep
+// writing to it. An entry that is popped or evicted while it's being written is
+// still counted as written
+func (r *RingBuffer) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for {
+		r.lock.Lock()
+		e := r.peek()
+		r.lock.Unlock()
+		if e == nil {
+			return n, nil
+		}
+
+		line := entryLine(append(make([]byte, 0, len(e.data)+1), e.data...))
+		m, err := w.Write(line)
+		n += int64(m)
+		if err == nil && m < len(line) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return n, err
+		}
+
+		r.lock.Lock()
+		r.confirm(e)
+		r.lock.Unlock()
+	}
+}
+
+// peek returns the entry Pop would return next without removing it, or nil if there
+// is none
+// The caller must hold r.lock
+func (r *RingBuffer) peek() *entry {
+	if _, rg := r.promoted(0); rg != nil {
+		return rg.Value.(*entry)
+	}
+
+	i, ok := r.drainPriority(0)
+	if !ok {
+		if j := r.stickyTop(); j >= 0 {
+			return r.sticky[j]
+		}
+		return nil
+	}
+
+	var e *entry
+	r.getRing(i).walk(func(rg *ring.Ring) bool {
+		e = rg.Value.(*entry)
+		return false
+	})
+	return e
+}
+
+// confirm removes e, which was returned by peek, as if it had been popped. It does
+// nothing if e is no longer stored
+// The caller must hold r.lock
+func (r *RingBuffer) confirm(e *entry) {
+	if pr := r.getRing(int(e.p)); pr != nil {
+		removed := false
+		pr.walk(func(rg *ring.Ring) bool {
+			if rg.Value == e {
+				r.removeAt(pr, rg)
+				removed = true
+			}
+			return !removed
+		})
+		if removed {
+			if r.weights != nil {
+				r.drained[e.p]++
+			}
+			return
+		}
+	}
+
+	for j, s := range r.sticky {
+		if s == e {
+			r.sticky = append(r.sticky[:j], r.sticky[j+1:]...)
+			r.size -= len(e.data)
+			return
+		}
+	}
+}
+
+// entryLine returns b followed by a newline, unless b already ends with one
+func entryLine(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		return b
+	}
+	return append(b, '\n')
+}
+
+// SetMaxLineLength sets the longest line, in bytes, that ReadFrom stores. Longer lines
+// are truncated. A length of 0 or less restores DefaultMaxLineLength
+func (r *RingBuffer) SetMaxLineLength(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.maxLine = max(n, 0)
+}
+
+// ReadFrom reads src until EOF and stores each line as an entry, without its newline,
+// at the priority Write uses, implementing io.ReaderFrom. A final line that doesn't
+// end with a newline is stored as well. Lines longer than the limit set by
+// SetMaxLineLength are truncated to the limit and marked with a suffix such as
+// " [plog] truncated 1024 bytes", so a source without newlines can't grow the
+// RingBuffer's memory without bound
+// ReadFrom returns the number of bytes read from src. It stops at the first read
+// error other than io.EOF, or at the first error from storing an entry
+func (r *RingBuffer) ReadFrom(src io.Reader) (int64, error) {
+	r.lock.Lock()
+	limit := r.maxLine
+	r.lock.Unlock()
+	if limit == 0 {
+		limit = DefaultMaxLineLength
+	}
+
+	br := bufio.NewReader(src)
+	var n int64
+	var line []byte
+	var truncated int
+	for {
+		chunk, err := br.ReadSlice('\n')
+		n += int64(len(chunk))
+		ended := err == nil
+		if ended {
+			chunk = chunk[:len(chunk)-1]
+		}
+
+		keep := min(len(chunk), limit-len(line))
+		line = append(line, chunk[:keep]...)
+		truncated += len(chunk) - keep
+
+		if ended || (err == io.EOF && (len(line) > 0 || truncated > 0)) {
+			if storeErr := r.storeLine(line, truncated); storeErr != nil {
+				return n, storeErr
+			}
+			// the stored line may be retained, so start a new one
+			line, truncated = nil, 0
+		}
+
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return n, nil
+		default:
+			return n, err
+		}
+	}
+}
+
+// storeLine stores a line read by ReadFrom, marking it if truncated bytes were cut
+func (r *RingBuffer) storeLine(line []byte, truncated int) error {
+	if truncated > 0 {
+		line = fmt.Appendf(line, " [plog] truncated %d bytes", truncated)
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+	defer r.writePanics()
+
+	return r.pwrite(r.writePriority(), line)
+}
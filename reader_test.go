@@ -0,0 +1,174 @@
+package plog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestEntryReader runs subtests covering EntryReader framing
+func TestEntryReader(t *testing.T) {
+	t.Run("copy", testEntryReaderCopy)
+	t.Run("framing", testEntryReaderFraming)
+	t.Run("write to", testEntryReaderWriteTo)
+	t.Run("write to short", testEntryReaderWriteToShort)
+	t.Run("read from", testEntryReaderReadFrom)
+	t.Run("read from long line", testEntryReaderReadFromLongLine)
+}
+
+func testEntryReaderReadFrom(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	src := "minor0\nminor1 is longer\nminor2"
+	rb.SetMaxLineLength(8)
+	n, err := rb.ReadFrom(strings.NewReader(src))
+	if err != nil || n != int64(len(src)) {
+		t.Logf("unexpected ReadFrom result %d, %v\n", n, err)
+		t.Fail()
+	}
+
+	popWithExpected("minor2", rb, false, t)
+	popWithExpected("minor1 i [plog] truncated 8 bytes", rb, false, t)
+	popWithExpected("minor0", rb, false, t)
+}
+
+// repeatReader returns n copies of c without any newlines
+type repeatReader struct {
+	c byte
+	n int64
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	k := int(min(int64(len(p)), r.n))
+	for i := range p[:k] {
+		p[i] = r.c
+	}
+	r.n -= int64(k)
+	return k, nil
+}
+
+// testEntryReaderReadFromLongLine asserts that a long reader without newlines is
+// stored as a single entry bounded by the default line length
+func testEntryReaderReadFromLongLine(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	const size = 8 << 20
+	n, err := rb.ReadFrom(&repeatReader{c: 'a', n: size})
+	if err != nil || n != size {
+		t.Logf("unexpected ReadFrom result %d, %v\n", n, err)
+		t.Fail()
+	}
+
+	s, err := rb.Pop(false)
+	marker := fmt.Sprintf(" [plog] truncated %d bytes", size-DefaultMaxLineLength)
+	if err != nil || len(s) != DefaultMaxLineLength+len(marker) || !strings.HasSuffix(s, marker) {
+		t.Logf("unexpected entry of %d bytes ending %q, %v\n", len(s), s[max(len(s)-40, 0):], err)
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+// testEntryReaderWriteTo asserts that WriteTo only removes entries that were written
+// and that a failed WriteTo can be retried without losing entries
+func testEntryReaderWriteTo(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+	rb.PWriteSticky(Major, []byte("sticky0"))
+	rb.Write([]byte("minor1"))
+
+	w := &errWriter{n: 2}
+	n, err := rb.WriteTo(w)
+	if err == nil || w.out.String() != "critical0\nminor1\n" || n != int64(w.out.Len()) {
+		t.Logf("unexpected first WriteTo result %d, %v, %q\n", n, err, w.out.String())
+		t.Fail()
+	}
+
+	var out bytes.Buffer
+	n, err = rb.WriteTo(&out)
+	expected := "minor0\nsticky0\n"
+	if err != nil || out.String() != expected || n != int64(len(expected)) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, out.String(), expected, n)
+		t.Fail()
+	}
+	if b := rb.Bytes(); b != 0 {
+		t.Logf("expected 0 bytes after WriteTo, got %d\n", b)
+		t.Fail()
+	}
+}
+
+// shortWriter is an io.Writer that accepts at most n bytes per write without an error
+type shortWriter struct {
+	n   int
+	out bytes.Buffer
+}
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	return w.out.Write(b[:min(len(b), w.n)])
+}
+
+// testEntryReaderWriteToShort asserts that RingBuffer implements io.WriterTo and that
+// a short write is reported with an accurate count and leaves its entry buffered
+func testEntryReaderWriteToShort(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0"))
+
+	var wt io.WriterTo = rb
+	w := &shortWriter{n: 4}
+	n, err := wt.WriteTo(w)
+	if err != io.ErrShortWrite || n != 4 || w.out.String() != "crit" {
+		t.Logf("unexpected WriteTo result %d, %v, %q\n", n, err, w.out.String())
+		t.Fail()
+	}
+
+	var out bytes.Buffer
+	n, err = wt.WriteTo(&out)
+	expected := "critical0\nminor0\n"
+	if err != nil || out.String() != expected || n != int64(len(expected)) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, out.String(), expected, n)
+		t.Fail()
+	}
+}
+
+func testEntryReaderCopy(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("minor0"))
+	rb.PWrite(Critical, []byte("critical0\n"))
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, rb.NewEntryReader())
+	expected := "critical0\nminor0\n"
+	if err != nil || out.String() != expected || n != int64(len(expected)) {
+		t.Logf("err: %v || %q != %q (%d bytes)\n", err, out.String(), expected, n)
+		t.Fail()
+	}
+}
+
+// testEntryReaderFraming asserts that each Read returns at most one entry and that
+// entries larger than p are continued by the following Reads
+func testEntryReaderFraming(t *testing.T) {
+	rb := NewRingBuffer(Minor, 3)
+	rb.Write([]byte("dory"))
+	rb.Write([]byte("nemo"))
+	er := rb.NewEntryReader()
+
+	p := make([]byte, 3)
+	for _, expected := range []string{"nem", "o\n", "dor", "y\n"} {
+		n, err := er.Read(p)
+		if err != nil || string(p[:n]) != expected {
+			t.Logf("err: %v || %q != %q\n", err, p[:n], expected)
+			t.Fail()
+		}
+	}
+	if _, err := er.Read(p); err != io.EOF {
+		t.Logf("expected %v, got %v\n", io.EOF, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,184 @@
+package plog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SliceBuffer stores entries in a slice and never overwrites them, which suits audit
+// logs where silently losing an entry is worse than refusing it. Entries are popped
+// in the same order as RingBuffer: highest priority first, newest first within a
+// priority
+//
+// A SliceBuffer created with a capacity of 0 is unbounded and grows as needed. With a
+// positive capacity, writes that would exceed it are rejected with ErrBufferFull and
+// the stored entries are left intact
+type SliceBuffer struct {
+	p       LogPriority
+	cap     int
+	entries []*entry
+	lock    *sync.Mutex
+}
+
+// NewSliceBuffer returns a SliceBuffer with default priority p that holds at most
+// capacity entries, or any number of entries if capacity is 0. A negative capacity
+// is treated as 0
+func NewSliceBuffer(p LogPriority, capacity int) *SliceBuffer {
+	return &SliceBuffer{
+		p:    p,
+		cap:  max(capacity, 0),
+		lock: &sync.Mutex{},
+	}
+}
+
+// GetPriority returns the SliceBuffer's default priority
+func (s *SliceBuffer) GetPriority() LogPriority {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.p
+}
+
+// SetPriority sets the SliceBuffer's default priority
+func (s *SliceBuffer) SetPriority(p LogPriority) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.p = p
+}
+
+// Available returns the number of entries that can be written before the SliceBuffer
+// is full, or -1 if it is unbounded
+func (s *SliceBuffer) Available() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cap == 0 {
+		return -1
+	}
+	return s.cap - len(s.entries)
+}
+
+// Len returns the number of entries in the SliceBuffer
+func (s *SliceBuffer) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.entries)
+}
+
+// cloneEmpty returns a SliceBuffer with the same default priority and capacity as s
+// and no entries
+func (s *SliceBuffer) cloneEmpty() Buffer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return NewSliceBuffer(s.p, s.cap)
+}
+
+// Write writes b at the default priority
+func (s *SliceBuffer) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return writeResult(b, s.pwrite(s.p, b))
+}
+
+// PWrite writes b with priority p, or returns ErrBufferFull if the SliceBuffer is full
+// The SliceBuffer stores a copy of b
+func (s *SliceBuffer) PWrite(p LogPriority, b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return writeResult(b, s.pwrite(p, b))
+}
+
+// PWriteBatch writes each of entries with priority p and returns the number stored
+// If the SliceBuffer fills up, the remaining entries are rejected with ErrBufferFull
+func (s *SliceBuffer) PWriteBatch(p LogPriority, entries [][]byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for k, b := range entries {
+		if err := s.pwrite(p, b); err != nil {
+			return k, err
+		}
+	}
+	return len(entries), nil
+}
+
+// pwrite stores a copy of b with priority p
+// The caller must hold s.lock
+func (s *SliceBuffer) pwrite(p LogPriority, b []byte) error {
+	if s.cap > 0 && len(s.entries) >= s.cap {
+		return ErrBufferFull
+	}
+
+	s.entries = append(s.entries, &entry{
+		data: append([]byte(nil), b...),
+		p:    p,
+		t:    time.Now(),
+	})
+	return nil
+}
+
+// Pop removes and returns the highest priority, newest entry
+func (s *SliceBuffer) Pop(priPrefix bool) (string, error) {
+	e, err := s.PopEntry()
+	if err != nil {
+		return "", err
+	}
+
+	if priPrefix {
+		return fmt.Sprintf("%s %s", PriorityString(e.Priority), e.Message), nil
+	}
+	return e.Message, nil
+}
+
+// PopEntry removes and returns the highest priority, newest entry along with the
+// priority and time it was written at
+func (s *SliceBuffer) PopEntry() (Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.entries) == 0 {
+		return Entry{}, ErrBufferEmpty
+	}
+
+	j := len(s.entries) - 1
+	for k := j - 1; k >= 0; k-- {
+		if s.entries[k].p > s.entries[j].p {
+			j = k
+		}
+	}
+
+	e := s.entries[j]
+	copy(s.entries[j:], s.entries[j+1:])
+	s.entries[len(s.entries)-1] = nil
+	s.entries = s.entries[:len(s.entries)-1]
+	return e.export(), nil
+}
+
+// ForEachEntry calls fn for each entry in the SliceBuffer, in the same order Pop would
+// return them, without removing any. Traversal stops early if fn returns false
+// fn is called while the SliceBuffer is locked, so it must not call SliceBuffer methods
+func (s *SliceBuffer) ForEachEntry(fn func(e Entry) bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// newest first, then stable sorted so higher priorities come first
+	entries := make([]*entry, len(s.entries))
+	for k, e := range s.entries {
+		entries[len(entries)-1-k] = e
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].p > entries[j].p
+	})
+	for _, e := range entries {
+		if !fn(e.export()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package plog
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSliceBuffer runs subtests covering SliceBuffer capacity semantics
+func TestSliceBuffer(t *testing.T) {
+	t.Run("order", testSliceBufferOrder)
+	t.Run("unbounded", testSliceBufferUnbounded)
+	t.Run("bounded", testSliceBufferBounded)
+}
+
+func testSliceBufferOrder(t *testing.T) {
+	sb := NewSliceBuffer(Minor, 0)
+	sb.Write([]byte("minor0"))
+	sb.PWrite(Critical, []byte("critical0"))
+	sb.Write([]byte("minor1"))
+
+	for _, expected := range []string{"Critical critical0", "Minor minor1", "Minor minor0"} {
+		if s, err := sb.Pop(true); err != nil || s != expected {
+			t.Logf("err: %v || %s != %s\n", err, s, expected)
+			t.Fail()
+		}
+	}
+	if _, err := sb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+}
+
+func testSliceBufferUnbounded(t *testing.T) {
+	sb := NewSliceBuffer(Minor, 0)
+	for i := 0; i < 2000; i++ {
+		if _, err := sb.Write([]byte(fmt.Sprint("minor", i))); err != nil {
+			t.Logf("unexpected write error at %d: %v\n", i, err)
+			t.FailNow()
+		}
+	}
+	if a := sb.Available(); a != -1 {
+		t.Logf("expected -1 available, got %d\n", a)
+		t.Fail()
+	}
+	for i := 1999; i >= 0; i-- {
+		if s, err := sb.Pop(false); err != nil || s != fmt.Sprint("minor", i) {
+			t.Logf("err: %v || %s != minor%d\n", err, s, i)
+			t.FailNow()
+		}
+	}
+}
+
+func testSliceBufferBounded(t *testing.T) {
+	sb := NewSliceBuffer(Minor, 2)
+	sb.Write([]byte("minor0"))
+	sb.Write([]byte("minor1"))
+	if n, err := sb.PWrite(Critical, []byte("critical0")); err != ErrBufferFull || n != 0 {
+		t.Logf("expected 0, %v, got %d, %v\n", ErrBufferFull, n, err)
+		t.Fail()
+	}
+	if a := sb.Available(); a != 0 {
+		t.Logf("expected 0 available, got %d\n", a)
+		t.Fail()
+	}
+
+	for _, expected := range []string{"minor1", "minor0"} {
+		if s, err := sb.Pop(false); err != nil || s != expected {
+			t.Logf("err: %v || %s != %s\n", err, s, expected)
+			t.Fail()
+		}
+	}
+	if _, err := sb.Write([]byte("minor2")); err != nil {
+		t.Logf("expected write to succeed after popping, got %v\n", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,133 @@
+package plog
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stressDuration is how long TestRingBufferStress runs its producers and consumers
+const stressDuration = 200 * time.Millisecond
+
+// countWriter is an io.Writer that counts newline-terminated lines written to it
+type countWriter struct {
+	lines int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	atomic.AddInt64(&w.lines, int64(bytes.Count(b, []byte{'\n'})))
+	return len(b), nil
+}
+
+// TestRingBufferStress runs producer goroutines writing random priorities alongside
+// consumer goroutines popping and reading the RingBuffer, then asserts that every
+// written entry was either popped, spilled by overflow, or is still buffered
+// Run it with -race to check the RingBuffer's locking
+func TestRingBufferStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	const producers, consumers = 8, 4
+	rb := NewRingBuffer(Minor, 16)
+	rb.SetMaxEntries(48)
+	spill := &countWriter{}
+	rb.SetSpillWriter(spill)
+
+	var written, popped int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				var err error
+				switch rnd.Intn(4) {
+				case 0:
+					_, err = rb.Write([]byte("nemo"))
+				case 1:
+					rb.SetPriority(LogPriority(rnd.Intn(4)))
+					continue
+				default:
+					_, err = rb.PWrite(LogPriority(rnd.Intn(100)-10), []byte("nemo"))
+				}
+				if err != nil {
+					t.Errorf("unexpected write error: %v", err)
+					return
+				}
+				atomic.AddInt64(&written, 1)
+			}
+		}(int64(i))
+	}
+	for i := 0; i < consumers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				var err error
+				switch i % 4 {
+				case 0:
+					_, err = rb.Pop(true)
+				case 1:
+					_, err = rb.PopEntry()
+				case 2:
+					_, err = rb.PopMin(Major)
+				default:
+					rb.GetPriority()
+					rb.Available()
+					rb.Bytes()
+					rb.HasPriority(Critical)
+					continue
+				}
+				if err == nil {
+					atomic.AddInt64(&popped, 1)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(stressDuration)
+	close(done)
+	wg.Wait()
+
+	if err := rb.Validate(); err != nil {
+		t.Logf("invalid RingBuffer after stress: %v\n", err)
+		t.Fail()
+	}
+	remaining := int64(len(rb.PopAll(false)))
+	spilled := atomic.LoadInt64(&spill.lines)
+	if written != popped+spilled+remaining {
+		t.Logf("written %d != popped %d + spilled %d + remaining %d\n",
+			written, popped, spilled, remaining)
+		t.Fail()
+	}
+	if written == 0 || popped == 0 {
+		t.Logf("expected writes and pops, got %d written and %d popped\n", written, popped)
+		t.Fail()
+	}
+	if _, err := rb.Pop(false); err != ErrBufferEmpty {
+		t.Logf("expected %v, got %v\n", ErrBufferEmpty, err)
+		t.Fail()
+	}
+	if b := rb.Bytes(); b != 0 {
+		t.Logf("expected 0 bytes after draining, got %d\n", b)
+		t.Fail()
+	}
+}